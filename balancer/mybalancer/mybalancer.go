@@ -7,9 +7,13 @@ package mybalancer
  * Builder负责生成Picker，而Picker负责真正的负载均衡策略的视线
  */
 import (
-	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
+	"grpc-case/logging"
+	"grpc-case/metrics"
+	"grpc-case/tracing"
 	"math/rand"
 )
 
@@ -17,6 +21,8 @@ import (
 const Name = "my_balancer"
 
 // newBuilder creates a new balancer builder.
+// HealthCheck: true 表示开启客户端健康检查，服务端NOT_SERVING的实例不会出现在ReadySCs中
+// 需要客户端的ServiceConfig中配置healthCheckConfig（见common.GenServiceConfig）才会真正生效
 func newBuilder() balancer.Builder {
 	return base.NewBalancerBuilder(Name, &myPickerBuilder{}, base.Config{HealthCheck: true})
 }
@@ -25,24 +31,32 @@ func init() {
 	balancer.Register(newBuilder())
 }
 
+// 可以通过logging.SetNamed("balancer.my", ...)注入logger
+func log() *zap.Logger {
+	return logging.Named("balancer.my")
+}
+
 type myPickerBuilder struct{}
 
 // Build方法会在连接状态改变时被调用, 永远传递最新的所有健康连接, 实现方需要通过这些信息实现出
 func (*myPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
-	fmt.Printf("myPickerBuilder Picker: Build called with info: %v\n", info)
+	log().Info("build picker", zap.Int("ready", len(info.ReadySCs)))
 	if len(info.ReadySCs) == 0 {
 		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
 	}
 
 	// 去除最新鲜的健康地址
 	scs := make([]balancer.SubConn, 0, len(info.ReadySCs))
-	for sc := range info.ReadySCs {
+	addrs := make([]string, 0, len(info.ReadySCs))
+	for sc, scInfo := range info.ReadySCs {
 		scs = append(scs, sc)
+		addrs = append(addrs, scInfo.Address.Addr)
 	}
 
 	// 将参数传递进来的最新健康连接保存
 	return &myPicker{
 		subConns: scs,
+		addrs:    addrs,
 	}
 }
 
@@ -51,17 +65,18 @@ type myPicker struct {
 	// created. The slice is immutable. Each Get() will do a customized
 	// selection from it and return the selected SubConn.
 	subConns []balancer.SubConn
+	addrs    []string // 和subConns一一对应，用于监控指标
 }
 
 // 真正的负载均衡的策略，也就根据定制策略选择一个实例
-func (p *myPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
-	if len(p.subConns) <= 1 {
-		return balancer.PickResult{SubConn: p.subConns[0]}, nil
-	}
+func (p *myPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	idx := 0
 	// 不管多少个实例，第一个实例90%的概率，第二个实例10%的概率，其他实例没有机会
-	if 0 != rand.Intn(10) {
-		return balancer.PickResult{SubConn: p.subConns[1]}, nil
-	} else {
-		return balancer.PickResult{SubConn: p.subConns[0]}, nil
+	if len(p.subConns) > 1 && 0 != rand.Intn(10) {
+		idx = 1
 	}
+	metrics.BalancerPicks.WithLabelValues(Name, p.addrs[idx]).Inc()
+	// info.Ctx就是RPC的ctx，客户端开启了链路追踪的话，这里就能拿到RPC的span
+	tracing.AddEvent(info.Ctx, "pick", attribute.String("balancer", Name), attribute.String("addr", p.addrs[idx]))
+	return balancer.PickResult{SubConn: p.subConns[idx]}, nil
 }
@@ -0,0 +1,238 @@
+package orcabalancer
+
+/*
+ * 按服务端上报的负载（ORCA）动态调整权重的balancer，和mybalancer并列，服务端的上报见loadreport包
+ * 负载报告有两个来源：
+ *  1. 每个请求的trailer，在PickResult.Done中拿到，请求越多更新越及时
+ *  2. 带外的流，实例ready之后通过orca.RegisterOOBListener订阅，间隔OOBInterval，请求很少时也能拿到
+ * 权重的计算：
+ *  利用率 u = max(CPU, 应用利用率, 自定义利用率) + InflightCost * 正在处理的请求数
+ *  有效权重 = 静态权重（discovery/attributes中的Weight，取resolver最近一次推送的地址）/ (MinUtilization + u)
+ *  还没有负载报告或者报告已经过期（ReportTTL）的实例，使用其他实例有效权重的平均值；所有实例都没有报告时按静态权重
+ * 每次Pick按有效权重随机选择一个实例
+ */
+import (
+	v3orcapb "github.com/cncf/xds/go/xds/data/orca/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/orca"
+	"grpc-case/discovery/attributes"
+	"grpc-case/loadreport"
+	"grpc-case/logging"
+	"grpc-case/metrics"
+	"grpc-case/tracing"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const Name = "orca_weighted"
+
+var (
+	// 负载报告的有效期，过期之后当作没有报告
+	ReportTTL = 10 * time.Second
+	// 带外上报的间隔，服务端默认最小30秒
+	OOBInterval = 30 * time.Second
+	// 每个正在处理的请求折算成多少利用率
+	InflightCost = 0.05
+	// 利用率的下限，避免空闲的实例权重无限大
+	MinUtilization = 0.1
+)
+
+func init() {
+	balancer.Register(&orcaBuilder{})
+}
+
+// 可以通过logging.SetNamed("balancer.orca", ...)注入logger
+func log() *zap.Logger {
+	return logging.Named("balancer.orca")
+}
+
+// 每个连接单独一个pickerBuilder，用来保存各个实例的负载
+// HealthCheck: true 和mybalancer一样，NOT_SERVING的实例不参与选择
+type orcaBuilder struct{}
+
+func (*orcaBuilder) Name() string {
+	return Name
+}
+
+func (*orcaBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &orcaPickerBuilder{loads: map[balancer.SubConn]*subConnLoad{}, weights: map[string]uint32{}}
+	b := base.NewBalancerBuilder(Name, pb, base.Config{HealthCheck: true}).Build(cc, opts)
+	return &orcaBalancer{Balancer: b, pb: pb}
+}
+
+// 在base的balancer上加一层：记下最新的静态权重，关闭时取消带外上报的订阅
+type orcaBalancer struct {
+	balancer.Balancer
+	pb *orcaPickerBuilder
+}
+
+// base的balancer按地址保存subConn，地址只在第一次出现时记下来，之后BalancerAttributes的变化（比如修改了权重）拿不到，
+// 所以这里先记下每个地址最新的权重，base重建picker时按Addr查
+func (b *orcaBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	weights := make(map[string]uint32, len(s.ResolverState.Addresses))
+	for _, a := range s.ResolverState.Addresses {
+		weights[a.Addr] = attributes.Weight(a)
+	}
+	b.pb.mu.Lock()
+	b.pb.weights = weights
+	b.pb.mu.Unlock()
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+func (b *orcaBalancer) ExitIdle() {
+	if ei, ok := b.Balancer.(balancer.ExitIdler); ok {
+		ei.ExitIdle()
+	}
+}
+
+func (b *orcaBalancer) Close() {
+	b.Balancer.Close()
+	b.pb.stopAll()
+}
+
+type orcaPickerBuilder struct {
+	mu      sync.Mutex
+	loads   map[balancer.SubConn]*subConnLoad
+	weights map[string]uint32 // 地址 => resolver最近一次推送的静态权重
+}
+
+// 连接状态变化时调用，已有实例的负载保留下来，新ready的实例订阅带外上报，不再ready的实例取消订阅
+func (pb *orcaPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	log().Info("build picker", zap.Int("ready", len(info.ReadySCs)))
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	for sc, l := range pb.loads {
+		if _, ok := info.ReadySCs[sc]; !ok {
+			l.stop()
+			delete(pb.loads, sc)
+		}
+	}
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	p := &orcaPicker{
+		subConns: make([]balancer.SubConn, 0, len(info.ReadySCs)),
+		loads:    make([]*subConnLoad, 0, len(info.ReadySCs)),
+		weights:  make([]float64, 0, len(info.ReadySCs)),
+	}
+	for sc, scInfo := range info.ReadySCs {
+		l, ok := pb.loads[sc]
+		if !ok {
+			l = &subConnLoad{addr: scInfo.Address.Addr}
+			l.stop = orca.RegisterOOBListener(sc, l, orca.OOBListenerOptions{ReportInterval: OOBInterval})
+			pb.loads[sc] = l
+		}
+		p.subConns = append(p.subConns, sc)
+		p.loads = append(p.loads, l)
+		w, ok := pb.weights[scInfo.Address.Addr]
+		if !ok {
+			w = attributes.Weight(scInfo.Address)
+		}
+		p.weights = append(p.weights, float64(w))
+	}
+	return p
+}
+
+func (pb *orcaPickerBuilder) stopAll() {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	for sc, l := range pb.loads {
+		l.stop()
+		delete(pb.loads, sc)
+	}
+}
+
+// 一个实例最近一次上报的负载，实现orca.OOBListener
+type subConnLoad struct {
+	addr        string
+	stop        func()
+	utilization atomic.Uint64 // math.Float64bits
+	updated     atomic.Int64  // 收到报告的时间（UnixNano），0表示还没有
+}
+
+func (l *subConnLoad) OnLoadReport(r *v3orcapb.OrcaLoadReport) {
+	if r == nil {
+		return
+	}
+	u := max(r.CpuUtilization, r.ApplicationUtilization)
+	for _, v := range r.Utilization {
+		u = max(u, v)
+	}
+	u += InflightCost * r.NamedMetrics[loadreport.InflightMetric]
+	l.utilization.Store(math.Float64bits(u))
+	l.updated.Store(time.Now().UnixNano())
+	metrics.BalancerUtilization.WithLabelValues(Name, l.addr).Set(u)
+}
+
+// 取出没有过期的利用率
+func (l *subConnLoad) get(now time.Time) (float64, bool) {
+	updated := l.updated.Load()
+	if updated == 0 || now.Sub(time.Unix(0, updated)) > ReportTTL {
+		return 0, false
+	}
+	return math.Float64frombits(l.utilization.Load()), true
+}
+
+type orcaPicker struct {
+	// 创建之后不再修改，负载保存在subConnLoad中，随报告实时变化
+	subConns []balancer.SubConn
+	loads    []*subConnLoad
+	weights  []float64 // 静态权重
+}
+
+func (p *orcaPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	now := time.Now()
+	effective := make([]float64, len(p.subConns))
+	var sum float64
+	var fresh int
+	for i, l := range p.loads {
+		effective[i] = -1
+		if u, ok := l.get(now); ok {
+			effective[i] = p.weights[i] / (MinUtilization + u)
+			sum += effective[i]
+			fresh++
+		}
+	}
+	// 没有报告的实例使用平均值，所有实例都没有报告时按静态权重
+	var total float64
+	for i := range effective {
+		if effective[i] < 0 {
+			if fresh > 0 {
+				effective[i] = sum / float64(fresh)
+			} else {
+				effective[i] = p.weights[i]
+			}
+		}
+		total += effective[i]
+	}
+
+	idx := len(effective) - 1
+	r := rand.Float64() * total
+	for i, w := range effective {
+		if r < w {
+			idx = i
+			break
+		}
+		r -= w
+	}
+
+	l := p.loads[idx]
+	metrics.BalancerPicks.WithLabelValues(Name, l.addr).Inc()
+	tracing.AddEvent(info.Ctx, "pick", attribute.String("balancer", Name), attribute.String("addr", l.addr))
+	return balancer.PickResult{
+		SubConn: p.subConns[idx],
+		// 服务端通过loadreport开启了per-call上报时，trailer中的负载报告在这里拿到
+		Done: func(di balancer.DoneInfo) {
+			if r, ok := di.ServerLoad.(*v3orcapb.OrcaLoadReport); ok {
+				l.OnLoadReport(r)
+			}
+		},
+	}, nil
+}
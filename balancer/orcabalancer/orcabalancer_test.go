@@ -0,0 +1,96 @@
+package orcabalancer_test
+
+import (
+	"context"
+	"fmt"
+	"grpc-case/balancer/orcabalancer"
+	"grpc-case/pb"
+	"grpc-case/testutil"
+	"testing"
+	"time"
+)
+
+const service = "hello_orca"
+
+// 三个实例注册到同一个服务，返回使用orca_weighted的客户端
+func setup(t *testing.T) ([]*testutil.Server, *testutil.Registry, pb.HelloServiceClient) {
+	t.Helper()
+	reg := testutil.NewRegistry()
+	servers := make([]*testutil.Server, 3)
+	for i := range servers {
+		servers[i] = testutil.StartServer(t, testutil.WithRegistry(reg, service))
+	}
+	client := testutil.NewHelloClient(t, testutil.EtcdTarget(service),
+		testutil.WithResolver(reg), testutil.WithBalancer(orcabalancer.Name))
+	return servers, reg, client
+}
+
+// 发出n个请求，返回每个实例处理的请求数
+func spread(t *testing.T, client pb.HelloServiceClient, servers []*testutil.Server, n int) []int64 {
+	t.Helper()
+	before := make([]int64, len(servers))
+	for i, s := range servers {
+		before[i] = s.Hello.Calls()
+	}
+	for i := 0; i < n; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := client.SayHello(ctx, &pb.HelloRequest{Name: "orca"})
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := make([]int64, len(servers))
+	for i, s := range servers {
+		got[i] = s.Hello.Calls() - before[i]
+	}
+	return got
+}
+
+// 其中一个实例的CPU升高之后，流量转移到其他实例
+func TestShiftAwayFromLoaded(t *testing.T) {
+	servers, _, client := setup(t)
+	// 所有实例都先收到请求，拿到一次负载报告
+	got := spread(t, client, servers, 300)
+	for i, n := range got {
+		if n == 0 {
+			t.Fatalf("server %d got no calls before load: %v", i, got)
+		}
+	}
+
+	servers[0].Load.Recorder().SetCPUUtilization(0.9)
+	spread(t, client, servers, 100) // 负载报告随请求的trailer更新
+	got = spread(t, client, servers, 1000)
+	t.Logf("calls after load: %v", got)
+	// 利用率还要加上正在处理的请求（InflightCost），空闲实例的有效权重约为1/0.15，高负载的约为1/1.05，正常情况下只分到10%左右
+	if got[0]*3 > got[1] || got[0]*3 > got[2] {
+		t.Fatalf("loaded server got %d calls, others %v", got[0], got[1:])
+	}
+
+	// 负载恢复之后流量回来
+	servers[0].Load.Recorder().SetCPUUtilization(0)
+	spread(t, client, servers, 100)
+	got = spread(t, client, servers, 1000)
+	if got[0]*2 < got[1] || got[0]*2 < got[2] {
+		t.Fatalf("recovered server got %d calls, others %v", got[0], got[1:])
+	}
+}
+
+// 修改etcd中的权重，picker使用新的权重，而不是subConn创建时的
+func TestWeightUpdate(t *testing.T) {
+	servers, reg, client := setup(t)
+	s := servers[0]
+	reg.Put(s.Key, fmt.Sprintf(`{"addr": %q, "weight": 8}`, s.Addr))
+
+	// 等resolver推送新的权重：8/(8+1+1)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := spread(t, client, servers, 200)
+		if got[0] > 200/2 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("weight not applied, calls %v", got)
+		}
+	}
+}
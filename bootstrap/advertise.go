@@ -0,0 +1,79 @@
+package bootstrap
+
+/*
+ * 注册到服务发现中的地址（advertise address）
+ * 监听的地址不一定能被客户端访问：比如监听 :0（随机端口）、0.0.0.0（所有网卡），或者在容器里监听的是容器内的地址，
+ * 所以注册时需要单独确定一个对外的地址，优先级：
+ *  1. 显式指定（-server.advertise 或者环境变量 GRPC_CASE_SERVER_ADVERTISE），可以只写host，端口用实际监听的端口
+ *  2. 监听的是具体的IP，直接使用
+ *  3. 监听的是所有网卡，从网卡中找一个非回环的IP，找不到就用127.0.0.1
+ */
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// 根据实际监听的地址，确定对外注册的地址
+func AdvertiseAddr(advertise string, lis net.Addr) (string, error) {
+	tcpAddr, ok := lis.(*net.TCPAddr)
+	if !ok {
+		return "", fmt.Errorf("unsupported listener address %v", lis)
+	}
+	port := strconv.Itoa(tcpAddr.Port)
+
+	if advertise != "" {
+		host, p, err := net.SplitHostPort(advertise)
+		if err != nil {
+			// 只写了host
+			return net.JoinHostPort(advertise, port), nil
+		}
+		if p == "" || p == "0" {
+			p = port
+		}
+		return net.JoinHostPort(host, p), nil
+	}
+
+	if !tcpAddr.IP.IsUnspecified() {
+		return net.JoinHostPort(tcpAddr.IP.String(), port), nil
+	}
+	ip, err := detectIP()
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// 从网卡中找一个可用的非回环IP，优先IPv4
+func detectIP() (net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var v6 net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			if ip4 := ipNet.IP.To4(); ip4 != nil {
+				return ip4, nil
+			}
+			if v6 == nil {
+				v6 = ipNet.IP
+			}
+		}
+	}
+	if v6 != nil {
+		return v6, nil
+	}
+	return net.IPv4(127, 0, 0, 1), nil
+}
@@ -0,0 +1,65 @@
+package bootstrap
+
+/*
+ * 根据配置创建grpc服务
+ */
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+	"grpc-case/config"
+	"grpc-case/middleware"
+	"grpc-case/token/auth"
+)
+
+// 根据配置创建grpc服务：TLS证书、keepalive和连接/消息的限制、并发限制、最低超时预算、摘流等待时间
+// 监听地址和监控端口由调用方根据cfg.Server自行处理，opts排在配置生成的选项之后，可以覆盖它们
+func NewServerFromConfig(cfg *config.Config, opts ...grpc.ServerOption) (*Server, error) {
+	creds, err := cfg.TLS.ServerCredentials()
+	if err != nil {
+		return nil, err
+	}
+	base := append([]grpc.ServerOption{grpc.Creds(creds), WithMinDeadlineBudget(cfg.Server.MinDeadlineBudget)}, grpcOptions(&cfg.Server)...)
+	if cfg.Server.AdaptiveLimit {
+		// 高优先级的appid需要通过token校验，没有配置auth时全部按普通优先级
+		check := func(ctx context.Context) error { return auth.Check(ctx, cfg.Auth) }
+		priority := middleware.TenantPriority(check, cfg.Server.PriorityTenants...)
+		base = append(base, WithConcurrencyLimiter(middleware.NewLimiter(middleware.WithPriorityFunc(priority))))
+	}
+	s := NewServer(append(base, opts...)...)
+	s.DrainDelay = cfg.Server.DrainDelay
+	s.MaxConnections = cfg.Server.MaxConnections
+	return s, nil
+}
+
+// keepalive以及连接、消息的限制，没有配置的项不设置，使用grpc的默认值
+func grpcOptions(c *config.ServerConfig) []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	ka := c.Keepalive
+	params := keepalive.ServerParameters{
+		MaxConnectionIdle:     ka.MaxConnectionIdle,
+		MaxConnectionAge:      ka.MaxConnectionAge,
+		MaxConnectionAgeGrace: ka.MaxConnectionAgeGrace,
+		Time:                  ka.Time,
+		Timeout:               ka.Timeout,
+	}
+	if params != (keepalive.ServerParameters{}) {
+		opts = append(opts, grpc.KeepaliveParams(params))
+	}
+	if ka.MinTime > 0 || ka.PermitWithoutStream {
+		opts = append(opts, grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             ka.MinTime,
+			PermitWithoutStream: ka.PermitWithoutStream,
+		}))
+	}
+	if c.MaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(uint32(c.MaxConcurrentStreams)))
+	}
+	if c.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(c.MaxRecvMsgSize))
+	}
+	if c.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(c.MaxSendMsgSize))
+	}
+	return opts
+}
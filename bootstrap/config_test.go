@@ -0,0 +1,99 @@
+package bootstrap_test
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-case/config"
+	"grpc-case/pb"
+	"grpc-case/testutil"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 连接到期（max_connection_age）之后服务端发GOAWAY，客户端重新连接，期间的请求都不失败
+func TestMaxConnectionAge(t *testing.T) {
+	s := testutil.StartServer(t, testutil.WithConfig(func(c *config.Config) {
+		c.Server.Keepalive.MaxConnectionAge = 100 * time.Millisecond
+		c.Server.Keepalive.MaxConnectionAgeGrace = time.Second
+	}))
+	var dials atomic.Int32
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		dials.Add(1)
+		return testutil.Dialer(ctx, addr)
+	}
+	client := testutil.NewHelloClient(t, s.Target(), testutil.WithDialOptions(grpc.WithContextDialer(dialer)))
+
+	for end := time.Now().Add(time.Second); time.Now().Before(end); {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := client.SayHello(ctx, &pb.HelloRequest{Name: "age"})
+		cancel()
+		if err != nil {
+			t.Fatalf("call failed while connections are recycled: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := dials.Load(); n < 3 {
+		t.Fatalf("dialed %d times in 1s, connections not recycled", n)
+	}
+}
+
+// 连接数和消息大小的限制
+func TestServerLimits(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  func(*config.Config)
+		run  func(t *testing.T, s *testutil.Server)
+	}{
+		{
+			name: "max recv msg size",
+			cfg:  func(c *config.Config) { c.Server.MaxRecvMsgSize = 1024 },
+			run: func(t *testing.T, s *testutil.Server) {
+				client := testutil.NewHelloClient(t, s.Target())
+				if _, err := client.SayHello(context.Background(), &pb.HelloRequest{Name: "small"}); err != nil {
+					t.Fatal(err)
+				}
+				_, err := client.SayHello(context.Background(), &pb.HelloRequest{Name: strings.Repeat("x", 2048)})
+				if status.Code(err) != codes.ResourceExhausted {
+					t.Fatalf("err = %v, want ResourceExhausted", err)
+				}
+			},
+		},
+		{
+			name: "max connections",
+			cfg:  func(c *config.Config) { c.Server.MaxConnections = 1 },
+			run: func(t *testing.T, s *testutil.Server) {
+				first := testutil.Dial(t, s.Target())
+				if _, err := pb.NewHelloServiceClient(first).SayHello(context.Background(), &pb.HelloRequest{Name: "first"}); err != nil {
+					t.Fatal(err)
+				}
+
+				// 超出的连接被直接关闭
+				second := pb.NewHelloServiceClient(testutil.Dial(t, s.Target(), testutil.WithoutWait()))
+				ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+				_, err := second.SayHello(ctx, &pb.HelloRequest{Name: "second"})
+				cancel()
+				if err == nil {
+					t.Fatal("second connection accepted over the limit")
+				}
+
+				// 第一个连接关闭之后名额归还
+				first.Close()
+				ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if _, err := second.SayHello(ctx, &pb.HelloRequest{Name: "second"}, grpc.WaitForReady(true)); err != nil {
+					t.Fatalf("second connection after the first closed: %v", err)
+				}
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.run(t, testutil.StartServer(t, testutil.WithConfig(c.cfg)))
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package bootstrap
+
+/*
+ * 连接数限制
+ *  超过上限的连接accept之后立即关闭，而不是像netutil.LimitListener那样阻塞accept：
+ *  客户端马上就能感知到失败，去连其他实例，而不是卡在内核的backlog里等到超时
+ */
+import (
+	"go.uber.org/zap"
+	"grpc-case/logging"
+	"grpc-case/metrics"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+type limitListener struct {
+	net.Listener
+	max    int64
+	active atomic.Int64
+}
+
+// 同时最多max个连接，max<=0表示不限制
+func limitListen(lis net.Listener, max int) net.Listener {
+	if max <= 0 {
+		return lis
+	}
+	return &limitListener{Listener: lis, max: int64(max)}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if n := l.active.Add(1); n > l.max {
+			l.active.Add(-1)
+			metrics.ServerRejectedConnections.Inc()
+			logging.L().Debug("too many connections, rejected", zap.String("remote", conn.RemoteAddr().String()), zap.Int64("max", l.max))
+			conn.Close()
+			continue
+		}
+		metrics.ServerConnections.Inc()
+		return &limitConn{Conn: conn, release: l.release}, nil
+	}
+}
+
+func (l *limitListener) release() {
+	l.active.Add(-1)
+	metrics.ServerConnections.Dec()
+}
+
+// 关闭时归还名额，只归还一次
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,182 @@
+/**
+ * 服务端启动的公共逻辑
+ * 各个例子的服务端都通过这里创建grpc服务，统一挂上一些通用的能力：
+ *  1. 标准的健康检查服务 grpc.health.v1.Health
+ *  2. 启动时先NOT_SERVING，真正开始Serve之后再切到SERVING
+ *  3. 服务端反射（reflection），方便grpcurl或者 go run . call 之类的工具直接调用
+ *  4. 收到退出信号时，先切回NOT_SERVING，等客户端把自己从ready列表里摘掉之后，再GracefulStop
+ *  5. 默认的拦截器：请求ID、日志、监控指标、参数校验、panic恢复，以及可选的并发限制、最低超时预算检查
+ *  6. 链路追踪（OpenTelemetry）的StatsHandler，TracerProvider由服务的main通过tracing.Init初始化，每个进程一次
+ */
+package bootstrap
+
+import (
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"grpc-case/logging"
+	"grpc-case/metrics"
+	"grpc-case/middleware"
+	"grpc-case/tracing"
+	"grpc-case/validate"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 收到退出信号之后，NOT_SERVING状态保持多久才真正停止服务
+// 客户端的健康检查是Watch流，正常情况下很快就能感知到，这里留一点余量
+const DefaultDrainDelay = 2 * time.Second
+
+// 默认拦截器的选项，和grpc自己的ServerOption一起传给NewServer
+// 嵌入grpc.EmptyServerOption，grpc.NewServer会忽略它们
+type serverOption struct {
+	grpc.EmptyServerOption
+	apply func(*serverOptions)
+}
+
+type serverOptions struct {
+	minDeadlineBudget time.Duration
+	limiter           *middleware.Limiter
+	recovery          []middleware.RecoveryOption
+}
+
+// 请求剩余的超时时间低于d就直接拒绝，0表示不检查（默认）
+func WithMinDeadlineBudget(d time.Duration) grpc.ServerOption {
+	return serverOption{apply: func(o *serverOptions) { o.minDeadlineBudget = d }}
+}
+
+// 自适应的并发限制，nil表示不限制（默认）
+func WithConcurrencyLimiter(l *middleware.Limiter) grpc.ServerOption {
+	return serverOption{apply: func(o *serverOptions) { o.limiter = l }}
+}
+
+// panic恢复的选项（自定义钩子、是否返回DebugInfo）
+func WithRecovery(opts ...middleware.RecoveryOption) grpc.ServerOption {
+	return serverOption{apply: func(o *serverOptions) { o.recovery = opts }}
+}
+
+// 包装一下grpc.Server，业务注册服务的方式不变（pb.RegisterXXXServer(s, ...)）
+type Server struct {
+	*grpc.Server
+	Health     *health.Server
+	DrainDelay time.Duration
+	// 同时最多接受多少个连接，0表示不限制，需要在Serve之前设置
+	MaxConnections int
+	// 摘流之前调用，比如先从注册中心注销，让客户端不再选中这个实例，阻塞到注销完成再开始等待DrainDelay
+	BeforeDrain func()
+}
+
+// 创建grpc服务，并注册健康检查服务和反射服务
+// 默认拦截器在最外层，业务通过opts传入的拦截器（grpc.ChainUnaryInterceptor）排在后面
+// opts中可以混合使用WithMinDeadlineBudget等本包的选项，同一个选项后面的覆盖前面的
+func NewServer(opts ...grpc.ServerOption) *Server {
+	var o serverOptions
+	grpcOpts := make([]grpc.ServerOption, 0, len(opts))
+	for _, opt := range opts {
+		if so, ok := opt.(serverOption); ok {
+			so.apply(&o)
+			continue
+		}
+		grpcOpts = append(grpcOpts, opt)
+	}
+	return newServer(&o, grpcOpts...)
+}
+
+func newServer(o *serverOptions, opts ...grpc.ServerOption) *Server {
+	l := logging.L()
+	unary := []grpc.UnaryServerInterceptor{
+		middleware.RequestIdUnaryServerInterceptor(),
+		logging.UnaryServerInterceptor(l),
+		metrics.UnaryServerInterceptor(),
+	}
+	stream := []grpc.StreamServerInterceptor{
+		middleware.RequestIdStreamServerInterceptor(),
+		logging.StreamServerInterceptor(l),
+		metrics.StreamServerInterceptor(),
+	}
+	// 并发限制放在监控之后，被拒绝的请求也能统计到
+	if o.limiter != nil {
+		unary = append(unary, middleware.ConcurrencyLimitUnaryServerInterceptor(o.limiter))
+		stream = append(stream, middleware.ConcurrencyLimitStreamServerInterceptor(o.limiter))
+	}
+	if o.minDeadlineBudget > 0 {
+		unary = append(unary, middleware.MinBudgetUnaryServerInterceptor(o.minDeadlineBudget))
+		stream = append(stream, middleware.MinBudgetStreamServerInterceptor(o.minDeadlineBudget))
+	}
+	// 根据proto中的(grpccase.validate.rules)校验请求参数
+	unary = append(unary, validate.UnaryServerInterceptor())
+	stream = append(stream, validate.StreamServerInterceptor())
+	// panic恢复放在最里层，这样外层的日志、监控都能看到返回的Internal错误
+	unary = append(unary, middleware.RecoveryUnaryServerInterceptor(o.recovery...))
+	stream = append(stream, middleware.RecoveryStreamServerInterceptor(o.recovery...))
+	opts = append([]grpc.ServerOption{
+		tracing.ServerOption(),
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}, opts...)
+	s := &Server{
+		Server:     grpc.NewServer(opts...),
+		Health:     health.NewServer(),
+		DrainDelay: DefaultDrainDelay,
+	}
+	healthpb.RegisterHealthServer(s.Server, s.Health)
+	reflection.Register(s.Server)
+	// 还没开始Serve，先标记为不可用
+	s.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	return s
+}
+
+// 启动服务，阻塞直到服务停止
+// 收到SIGINT/SIGTERM时会先进入摘流（drain）阶段，然后优雅退出
+func (s *Server) Serve(lis net.Listener) error {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case sig := <-sigCh:
+			logging.L().Info("draining", zap.String("signal", sig.String()), zap.Duration("delay", s.DrainDelay))
+			s.Drain()
+		case <-done:
+		}
+	}()
+
+	s.setStatus(healthpb.HealthCheckResponse_SERVING)
+	return s.Server.Serve(limitListen(lis, s.MaxConnections))
+}
+
+// 摘流并优雅退出：先BeforeDrain（如果有）和NOT_SERVING，等待DrainDelay，再GracefulStop
+func (s *Server) Drain() {
+	if s.BeforeDrain != nil {
+		s.BeforeDrain()
+	}
+	s.Health.Shutdown()
+	time.Sleep(s.DrainDelay)
+	s.GracefulStop()
+}
+
+// 设置整体（""）以及所有已注册服务的健康状态
+func (s *Server) setStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	s.Health.SetServingStatus("", status)
+	for name := range s.GetServiceInfo() {
+		if isInternalService(name) {
+			continue
+		}
+		s.Health.SetServingStatus(name, status)
+	}
+}
+
+// 健康检查和反射本身不需要设置健康状态
+func isInternalService(name string) bool {
+	return name == healthpb.Health_ServiceDesc.ServiceName ||
+		strings.HasPrefix(name, "grpc.reflection.")
+}
@@ -0,0 +1,242 @@
+/**
+ * 命令行工具：动态调用任意grpc方法
+ * 依赖服务端开启反射（bootstrap.NewServer默认已开启），通过反射拿到方法的描述信息，
+ * 然后把JSON请求体转成protobuf消息发出去，再把结果转成JSON打印出来
+ *
+ * 用法：
+ *  go run . call -target 127.0.0.1:9090 -method HelloService/SayHello -d '{"name":"foo"}'
+ *  go run . call -target etcd:///myservicename_etcd -method HelloService.SayHello -d '{"name":"foo"}'
+ *  go run . call -target 127.0.0.1:9090 -ca tls/key/test.pem -server-name www.hq.com -method ...
+ *  go run . call -target 127.0.0.1:9090 -appid 123 -appkey abc -method ...
+ */
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer/roundrobin"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	_ "google.golang.org/grpc/health" // 开启客户端健康检查
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	_ "grpc-case/balancer/mybalancer" // 注册自定义的balancer
+	"grpc-case/common"
+	"grpc-case/config"
+	_ "grpc-case/discovery/basic/client/resolver"    // 注册myscheme1:///
+	_ "grpc-case/discovery/etcd/client/resolver"     // 注册etcd:///
+	_ "grpc-case/discovery/failover/client/resolver" // 注册failover:///
+	_ "grpc-case/discovery/file/client/resolver"     // 注册file:///
+	_ "grpc-case/discovery/srv/client/resolver"      // 注册srv:///
+	"grpc-case/metrics"
+	"grpc-case/token/auth"
+	"grpc-case/tracing"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// call子命令的入口，args不包括"call"本身
+func Call(args []string) error {
+	fs := flag.NewFlagSet("call", flag.ContinueOnError)
+	target := fs.String("target", common.BackEnd0, "target, e.g. 127.0.0.1:9090, etcd:///svc, myscheme1:///svc")
+	method := fs.String("method", "", "method, e.g. HelloService/SayHello")
+	data := fs.String("d", "{}", "request body in JSON, @file to read from file, @- to read from stdin")
+	caFile := fs.String("ca", "", "CA/server certificate, enable TLS if not empty")
+	serverName := fs.String("server-name", "", "override TLS server name")
+	appId := fs.String("appid", "", "appId for token auth")
+	appKey := fs.String("appkey", "", "appKey for token auth")
+	lb := fs.String("lb", roundrobin.Name, "load balancing policy")
+	timeout := fs.Duration("timeout", 5*time.Second, "call timeout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *method == "" {
+		return errors.New("-method is required")
+	}
+
+	body, err := readBody(*data)
+	if err != nil {
+		return err
+	}
+
+	// 链路追踪，是否导出由环境变量OTEL_TRACES_EXPORTER决定
+	shutdown, err := tracing.Init(context.Background(), "grpc-case-cli")
+	if err != nil {
+		return err
+	}
+	defer shutdown(context.Background())
+
+	// 组装连接参数
+	opts := []grpc.DialOption{
+		grpc.WithDefaultServiceConfig(common.GenServiceConfig(*lb)),
+		grpc.WithChainUnaryInterceptor(metrics.UnaryClientInterceptor()), // 监控
+		tracing.DialOption(), // 链路追踪
+	}
+	if *caFile != "" {
+		creds, err := credentials.NewClientTLSFromFile(*caFile, *serverName)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	if *appId != "" || *appKey != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(&auth.Credentials{Auth: config.AuthConfig{AppId: *appId, AppKey: *appKey}}))
+	}
+
+	conn, err := grpc.NewClient(*target, opts...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	rsp, err := Invoke(ctx, conn, *method, body)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(rsp))
+	return nil
+}
+
+// 通过反射动态调用一个方法，请求和返回都是JSON
+func Invoke(ctx context.Context, conn *grpc.ClientConn, method string, reqJson []byte) ([]byte, error) {
+	serviceName, methodName, err := parseMethod(method)
+	if err != nil {
+		return nil, err
+	}
+
+	md, err := resolveMethod(ctx, conn, serviceName, methodName)
+	if err != nil {
+		return nil, err
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("streaming method %v is not supported", md.FullName())
+	}
+
+	req := dynamicpb.NewMessage(md.Input())
+	if err := protojson.Unmarshal(reqJson, req); err != nil {
+		return nil, fmt.Errorf("invalid request body: %v", err)
+	}
+	rsp := dynamicpb.NewMessage(md.Output())
+
+	fullMethod := fmt.Sprintf("/%v/%v", md.Parent().FullName(), md.Name())
+	if err := conn.Invoke(ctx, fullMethod, req, rsp); err != nil {
+		return nil, err
+	}
+	return protojson.MarshalOptions{Multiline: true, EmitUnpopulated: true}.Marshal(rsp)
+}
+
+// 支持 Service/Method、Service.Method 以及 /Service/Method 三种写法
+func parseMethod(method string) (string, string, error) {
+	method = strings.TrimPrefix(method, "/")
+	pos := strings.LastIndex(method, "/")
+	if pos < 0 {
+		pos = strings.LastIndex(method, ".")
+	}
+	if pos <= 0 || pos == len(method)-1 {
+		return "", "", fmt.Errorf("invalid method: %v", method)
+	}
+	return method[:pos], method[pos+1:], nil
+}
+
+// 通过服务端反射，拿到方法的描述信息
+func resolveMethod(ctx context.Context, conn *grpc.ClientConn, serviceName, methodName string) (protoreflect.MethodDescriptor, error) {
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+
+	// 服务端返回的是包含该symbol的文件以及它依赖的文件，依赖的文件如果没返回，再按文件名逐个请求
+	fdps := map[string]*descriptorpb.FileDescriptorProto{}
+	pending := []*reflectionpb.ServerReflectionRequest{{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: serviceName},
+	}}
+	for len(pending) > 0 {
+		req := pending[0]
+		pending = pending[1:]
+		if err := stream.Send(req); err != nil {
+			return nil, err
+		}
+		rsp, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if e := rsp.GetErrorResponse(); e != nil {
+			return nil, fmt.Errorf("reflection error: %v", e.GetErrorMessage())
+		}
+		for _, b := range rsp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fdp := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(b, fdp); err != nil {
+				return nil, err
+			}
+			fdps[fdp.GetName()] = fdp
+		}
+		for _, fdp := range fdps {
+			for _, dep := range fdp.GetDependency() {
+				if _, ok := fdps[dep]; ok || inPending(pending, dep) {
+					continue
+				}
+				pending = append(pending, &reflectionpb.ServerReflectionRequest{
+					MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+				})
+			}
+		}
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, fdp := range fdps {
+		set.File = append(set.File, fdp)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(serviceName))
+	if err != nil {
+		return nil, err
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a service", serviceName)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(methodName))
+	if md == nil {
+		return nil, fmt.Errorf("method %v not found in service %v", methodName, serviceName)
+	}
+	return md, nil
+}
+
+func inPending(pending []*reflectionpb.ServerReflectionRequest, filename string) bool {
+	for _, r := range pending {
+		if r.GetFileByFilename() == filename {
+			return true
+		}
+	}
+	return false
+}
+
+// 读取请求体：直接是JSON，或者@文件名，或者@-表示标准输入
+func readBody(data string) ([]byte, error) {
+	if !strings.HasPrefix(data, "@") {
+		return []byte(data), nil
+	}
+	if data == "@-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(data[1:])
+}
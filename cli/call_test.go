@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-case/config"
+	"grpc-case/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+var token = config.AuthConfig{AppId: "123", AppKey: "abc"}
+
+// 通过反射调用SayHello，三种方法名的写法都可以
+func TestInvoke(t *testing.T) {
+	s := testutil.StartServer(t, testutil.WithAuth(token))
+	conn := testutil.Dial(t, s.Target(), testutil.WithToken(token))
+
+	cases := []struct {
+		name   string
+		method string
+		body   string
+		want   string // 回复的message，空表示调用失败
+		errMsg string
+	}{
+		{name: "slash", method: "HelloService/SayHello", body: `{"name":"foo"}`, want: "Hello foo"},
+		{name: "dot", method: "HelloService.SayHello", body: `{"name":"bar"}`, want: "Hello bar"},
+		{name: "leading slash", method: "/HelloService/SayHello", body: `{"name":"baz"}`, want: "Hello baz"},
+		{name: "unknown method", method: "HelloService/SayBye", body: `{}`, errMsg: "method SayBye not found"},
+		{name: "unknown service", method: "NoService/SayHello", body: `{}`, errMsg: "reflection error"},
+		{name: "invalid method", method: "SayHello", body: `{}`, errMsg: "invalid method"},
+		{name: "invalid body", method: "HelloService/SayHello", body: `{"nope":1}`, errMsg: "invalid request body"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			rsp, err := Invoke(ctx, conn, c.method, []byte(c.body))
+			if c.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), c.errMsg) {
+					t.Fatalf("err = %v, want %q", err, c.errMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var reply struct{ Message string }
+			if err := json.Unmarshal(rsp, &reply); err != nil {
+				t.Fatalf("invalid json %q: %v", rsp, err)
+			}
+			if reply.Message != c.want {
+				t.Fatalf("message = %q, want %q", reply.Message, c.want)
+			}
+		})
+	}
+}
+
+// call子命令：-appid/-appkey通过auth.Credentials带给服务端
+func TestCall(t *testing.T) {
+	s := testutil.StartServer(t, testutil.WithAuth(token), testutil.WithTCP())
+	args := []string{"-target", s.Addr, "-method", "HelloService/SayHello", "-d", `{"name":"cli"}`, "-timeout", "2s"}
+
+	if err := Call(append(args, "-appid", token.AppId, "-appkey", token.AppKey)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Call(args); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("without token: %v", err)
+	}
+	if err := Call(append(args, "-appid", token.AppId, "-appkey", "wrong")); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("wrong key: %v", err)
+	}
+	if got := s.Hello.Calls(); got != 1 {
+		t.Fatalf("calls = %d, want 1", got)
+	}
+}
@@ -1,6 +1,11 @@
 package common
 
-import "strings"
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
 
 const (
 	BackEndPort0 = "9090"
@@ -19,6 +24,13 @@ const (
 	AppKey = "abc"
 )
 
+// metadata中的key，注意必须全小写（metadata.FromIncomingContext有注释解释）
+const (
+	MetaAppId     = "appid"
+	MetaAppKey    = "appkey"
+	MetaRequestId = "x-request-id"
+)
+
 const (
 	EtcdAddr    = "127.0.0.1:2379"
 	EtcdTimeout = 1
@@ -34,6 +46,15 @@ func GenBasePath(scheme, serviceName string) string {
 	return "/" + strings.Join([]string{scheme, serviceName}, "/")
 }
 
+// 服务的动态配置（ServiceConfig，JSON格式）在Etcd中的路径：
+// $scheme/$serviceName/__config__
+// 比如切换负载均衡策略：etcdctl put /etcd/myservicename_etcd/__config__ '{"loadBalancingPolicy":"my_balancer"}'
+const ConfigKey = "__config__"
+
+func GenConfigPath(scheme, serviceName string) string {
+	return GenInstancePath(scheme, serviceName, ConfigKey)
+}
+
 // 服务发现遵循RFC 3986，比如服务地址 xxx:///yyyy，则xxx就是Scheme，yyyy实际上是解析后的Path，把它作为service名字来使用
 const (
 	// Note: 注意有坑，值不能有大写字母！！
@@ -48,3 +69,28 @@ const (
 	MyServiceNameEtcd = "myservicename_etcd"
 	AddressEtcd       = MySchemeEtcd + ":///" + MyServiceNameEtcd
 )
+
+// 生成客户端的ServiceConfig：指定负载均衡策略，同时开启客户端健康检查
+// healthCheckConfig.serviceName为空，表示检查服务端整体的健康状态
+// Note: 客户端需要 import _ "google.golang.org/grpc/health"，否则健康检查不会生效
+func GenServiceConfig(lbPolicy string) string {
+	return fmt.Sprintf(`{"loadBalancingPolicy":"%v","healthCheckConfig":{"serviceName":""}}`, lbPolicy)
+}
+
+// 指数退避：第retries次（从0开始）重试前等待的时间，base * 1.6^retries，不超过max，并加上±20%的随机抖动
+// 参数和grpc的backoff.DefaultConfig一致，避免大量实例同时重试
+func Backoff(retries int, base, max time.Duration) time.Duration {
+	backoff := float64(base)
+	for backoff < float64(max) && retries > 0 {
+		backoff *= 1.6
+		retries--
+	}
+	if backoff > float64(max) {
+		backoff = float64(max)
+	}
+	backoff *= 1 + 0.2*(rand.Float64()*2-1)
+	if backoff < 0 {
+		return 0
+	}
+	return time.Duration(backoff)
+}
@@ -0,0 +1,140 @@
+/**
+ * 配置
+ * 代替common/define.go中写死的常量，以及tls例子中写死的证书绝对路径
+ * 加载的优先级（后面的覆盖前面的）：
+ *  1. 默认值（Default()，和原来common中的常量保持一致）
+ *  2. 配置文件：-config xxx.yaml（YAML或者JSON都可以，JSON本身就是合法的YAML）
+ *  3. 环境变量：GRPC_CASE_<段>_<字段>，比如 GRPC_CASE_REGISTRY_ENDPOINTS=127.0.0.1:2379,127.0.0.1:22379
+ *  4. 命令行参数：-<段>.<字段>，比如 -server.port 9091，以及各个例子自己定义的简写（比如 -p 9091）
+ * 加载之后会做校验，有问题的话一次性把所有错误都列出来
+ */
+package config
+
+import (
+	"grpc-case/common"
+	"net"
+	"time"
+)
+
+// 环境变量的前缀
+const EnvPrefix = "GRPC_CASE_"
+
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Client   ClientConfig   `yaml:"client"`
+	TLS      TLSConfig      `yaml:"tls"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Registry RegistryConfig `yaml:"registry"`
+	Balancer BalancerConfig `yaml:"balancer"`
+}
+
+// 服务端
+type ServerConfig struct {
+	Host              string        `yaml:"host" usage:"listen host, empty means all interfaces"`
+	Port              string        `yaml:"port" usage:"listen port, 0 means a random port"`
+	Advertise         string        `yaml:"advertise" usage:"address registered to the registry, host or host:port, empty means detect"`
+	Name              string        `yaml:"name" usage:"service name registered to the registry"`
+	MetricsAddr       string        `yaml:"metrics_addr" usage:"metrics listen address, empty means disabled"`
+	DrainDelay        time.Duration `yaml:"drain_delay" usage:"how long to stay NOT_SERVING before stopping"`
+	MinDeadlineBudget time.Duration `yaml:"min_deadline_budget" usage:"reject calls with less time left, 0 means disabled"`
+	Gateway           bool          `yaml:"gateway" usage:"serve HTTP/JSON gateway on the same port"`
+	GatewayAddr       string        `yaml:"gateway_addr" usage:"HTTP gateway listen address when TLS is enabled (TLS traffic can't be split on one port)"`
+	AdaptiveLimit     bool          `yaml:"adaptive_limit" usage:"limit concurrent calls, adjusted from observed latency"`
+	PriorityTenants   []string      `yaml:"priority_tenants" usage:"app ids admitted first when overloaded (only after their token is verified), comma separated"`
+
+	// 连接和消息的限制，0表示使用grpc的默认值
+	Keepalive            KeepaliveConfig `yaml:"keepalive"`
+	MaxConcurrentStreams int             `yaml:"max_concurrent_streams" usage:"max concurrent streams per connection, 0 means unlimited"`
+	MaxRecvMsgSize       int             `yaml:"max_recv_msg_size" usage:"max size in bytes of a received message, 0 means 4MB"`
+	MaxSendMsgSize       int             `yaml:"max_send_msg_size" usage:"max size in bytes of a sent message, 0 means unlimited"`
+	MaxConnections       int             `yaml:"max_connections" usage:"max open connections, extra ones are closed at once, 0 means unlimited"`
+}
+
+// 服务端的keepalive
+// 长连接不会自己断开，客户端（特别是pick_first）一直连着最初的那几个实例，新扩容的实例分不到流量；
+// 设置max_connection_age之后，连接到期时服务端发GOAWAY，客户端重新解析、重新连接，流量就重新分布了
+type KeepaliveConfig struct {
+	Time                  time.Duration `yaml:"time" usage:"ping the client after the connection is idle this long, 0 means 2h"`
+	Timeout               time.Duration `yaml:"timeout" usage:"close the connection if a ping is not acked in time, 0 means 20s"`
+	MinTime               time.Duration `yaml:"min_time" usage:"minimum interval of client pings, clients pinging faster are disconnected, 0 means 5m"`
+	PermitWithoutStream   bool          `yaml:"permit_without_stream" usage:"allow client pings when there are no active streams"`
+	MaxConnectionIdle     time.Duration `yaml:"max_connection_idle" usage:"close connections idle for this long, 0 means never"`
+	MaxConnectionAge      time.Duration `yaml:"max_connection_age" usage:"close connections older than this (with ±10% jitter) so clients rebalance, 0 means never"`
+	MaxConnectionAgeGrace time.Duration `yaml:"max_connection_age_grace" usage:"time given to pending calls after max_connection_age, 0 means unlimited"`
+}
+
+// 客户端
+type ClientConfig struct {
+	Target      string        `yaml:"target" usage:"grpc target, e.g. 127.0.0.1:9090, etcd:///svc"`
+	Timeout     time.Duration `yaml:"timeout" usage:"timeout of each call"`
+	MetricsAddr string        `yaml:"metrics_addr" usage:"metrics listen address, empty means disabled"`
+	// 调用下游时从剩余的超时时间中预留给自己处理结果的时间，见middleware.DeadlineUnaryClientInterceptor
+	DeadlineMargin time.Duration `yaml:"deadline_margin" usage:"time kept for handling the reply when passing the deadline downstream"`
+}
+
+// TLS证书，路径是相对于运行目录的（在仓库根目录下 go run ./tls/server 即可）
+type TLSConfig struct {
+	Enabled    bool   `yaml:"enabled" usage:"enable TLS"`
+	CertFile   string `yaml:"cert_file" usage:"server certificate"`
+	KeyFile    string `yaml:"key_file" usage:"server private key"`
+	CAFile     string `yaml:"ca_file" usage:"certificate used by the client to verify the server"`
+	ServerName string `yaml:"server_name" usage:"server name used by the client to verify the certificate"`
+}
+
+// token认证（appId/appKey）
+type AuthConfig struct {
+	AppId  string `yaml:"app_id" usage:"appId for token auth"`
+	AppKey string `yaml:"app_key" usage:"appKey for token auth"`
+}
+
+// 服务注册中心
+type RegistryConfig struct {
+	Scheme      string        `yaml:"scheme" usage:"resolver scheme"`
+	Endpoints   []string      `yaml:"endpoints" usage:"etcd endpoints, comma separated"`
+	DialTimeout time.Duration `yaml:"dial_timeout" usage:"etcd dial timeout"`
+	LeaseTTL    int64         `yaml:"lease_ttl" usage:"lease ttl in seconds"`
+}
+
+// 负载均衡
+type BalancerConfig struct {
+	Policy string `yaml:"policy" usage:"load balancing policy, e.g. round_robin, my_balancer"`
+}
+
+// 默认配置，和原来common中的常量保持一致
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:       common.BackEndPort0,
+			Name:       common.MyServiceNameEtcd,
+			DrainDelay: 2 * time.Second,
+		},
+		Client: ClientConfig{
+			Target:  common.BackEnd0,
+			Timeout: time.Second,
+		},
+		TLS: TLSConfig{
+			CertFile:   "tls/key/test.pem",
+			KeyFile:    "tls/key/test.key",
+			CAFile:     "tls/key/test.pem",
+			ServerName: "*.hq.com",
+		},
+		Auth: AuthConfig{
+			AppId:  common.AppId,
+			AppKey: common.AppKey,
+		},
+		Registry: RegistryConfig{
+			Scheme:      common.MySchemeEtcd,
+			Endpoints:   []string{common.EtcdAddr},
+			DialTimeout: common.EtcdTimeout * time.Second,
+			LeaseTTL:    2,
+		},
+		Balancer: BalancerConfig{
+			Policy: "round_robin",
+		},
+	}
+}
+
+// 服务端监听的地址，IPv6的host会加上方括号（[::1]:9090）
+func (s *ServerConfig) ListenAddr() string {
+	return net.JoinHostPort(s.Host, s.Port)
+}
@@ -0,0 +1,192 @@
+package config
+
+import (
+	_ "google.golang.org/grpc/balancer/roundrobin"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 默认值 < 配置文件 < 环境变量 < 命令行参数
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "server:\n  port: \"9101\"\n  name: from_file\nclient:\n  timeout: 3s\n")
+	cases := []struct {
+		name string
+		file bool
+		env  map[string]string
+		args []string
+		port string
+		svc  string
+	}{
+		{name: "default", port: Default().Server.Port, svc: Default().Server.Name},
+		{name: "file", file: true, port: "9101", svc: "from_file"},
+		{name: "env over file", file: true, env: map[string]string{"GRPC_CASE_SERVER_PORT": "9102"}, port: "9102", svc: "from_file"},
+		{name: "flag over env", file: true, env: map[string]string{"GRPC_CASE_SERVER_PORT": "9102", "GRPC_CASE_SERVER_NAME": "from_env"},
+			args: []string{"-server.port", "9103"}, port: "9103", svc: "from_env"},
+		{name: "env without file", env: map[string]string{"GRPC_CASE_SERVER_NAME": "from_env"}, port: Default().Server.Port, svc: "from_env"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for k, v := range c.env {
+				t.Setenv(k, v)
+			}
+			args := c.args
+			if c.file {
+				args = append([]string{"-config", file}, args...)
+			}
+			cfg, err := Load(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Port != c.port || cfg.Server.Name != c.svc {
+				t.Fatalf("port = %q, name = %q, want %q, %q", cfg.Server.Port, cfg.Server.Name, c.port, c.svc)
+			}
+			// 没有覆盖的配置保持文件或者默认值
+			wantTimeout := Default().Client.Timeout
+			if c.file {
+				wantTimeout = 3 * time.Second
+			}
+			if cfg.Client.Timeout != wantTimeout {
+				t.Fatalf("client.timeout = %v, want %v", cfg.Client.Timeout, wantTimeout)
+			}
+		})
+	}
+}
+
+// WithDefaults在配置文件之前生效，各种类型的字段都能通过环境变量和命令行参数设置
+func TestLoadTypes(t *testing.T) {
+	t.Setenv("GRPC_CASE_REGISTRY_ENDPOINTS", "127.0.0.1:2379, 127.0.0.1:22379")
+	t.Setenv("GRPC_CASE_REGISTRY_LEASE_TTL", "5")
+	cfg, err := Load([]string{"-server.gateway", "-client.deadline_margin", "50ms", "-server.keepalive.max_connection_age", "1m"},
+		WithDefaults(func(c *Config) { c.Server.Name = "from_defaults" }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Name != "from_defaults" {
+		t.Fatalf("server.name = %q", cfg.Server.Name)
+	}
+	if want := []string{"127.0.0.1:2379", "127.0.0.1:22379"}; !reflect.DeepEqual(cfg.Registry.Endpoints, want) {
+		t.Fatalf("registry.endpoints = %v, want %v", cfg.Registry.Endpoints, want)
+	}
+	if cfg.Registry.LeaseTTL != 5 || !cfg.Server.Gateway || cfg.Client.DeadlineMargin != 50*time.Millisecond ||
+		cfg.Server.Keepalive.MaxConnectionAge != time.Minute {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	if _, err := Load([]string{"-registry.lease_ttl", "abc"}); err == nil {
+		t.Fatal("invalid int accepted")
+	}
+	t.Setenv("GRPC_CASE_CLIENT_TIMEOUT", "soon")
+	if _, err := Load(nil); err == nil || !strings.Contains(err.Error(), "GRPC_CASE_CLIENT_TIMEOUT") {
+		t.Fatalf("invalid env: %v", err)
+	}
+}
+
+// 简写和完整的参数写到同一个配置上，后出现的生效；简写指向不存在的配置时报错
+func TestLoadAlias(t *testing.T) {
+	opts := []Option{Alias("p", "server.port"), Alias("lb", "balancer.policy")}
+	cases := []struct {
+		name string
+		args []string
+		port string
+	}{
+		{"alias", []string{"-p", "9200"}, "9200"},
+		{"alias then full", []string{"-p", "9200", "-server.port", "9201"}, "9201"},
+		{"full then alias", []string{"-server.port", "9201", "-p", "9200"}, "9200"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg, err := Load(c.args, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Port != c.port {
+				t.Fatalf("server.port = %q, want %q", cfg.Server.Port, c.port)
+			}
+		})
+	}
+
+	if _, err := Load(nil, Alias("x", "server.nope")); err == nil || !strings.Contains(err.Error(), "server.nope") {
+		t.Fatalf("unknown alias path: %v", err)
+	}
+}
+
+// 所有的问题一次性列出来，每个问题一行
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.Server.Port = "70000"
+	cfg.Server.Name = "MyService"
+	cfg.Client.Timeout = 0
+	cfg.Auth.AppKey = ""
+	cfg.Registry.Endpoints = nil
+	cfg.Server.Keepalive.MaxConnectionAge = -time.Second
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	want := []string{
+		`server.port: must be a number between 0 and 65535, got "70000"`,
+		`server.name: must be lower case, got "MyService"`,
+		`server.keepalive.max_connection_age: must not be negative`,
+		`client.timeout: must be positive, got 0s`,
+		`auth: app_id and app_key must be set together`,
+		`registry.endpoints: must not be empty`,
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d errors, want %d:\n%v", len(lines), len(want), err)
+	}
+	for _, w := range want {
+		found := false
+		for _, l := range lines {
+			found = found || l == w
+		}
+		if !found {
+			t.Fatalf("missing %q in:\n%v", w, err)
+		}
+	}
+	// errors.Join的结果可以逐个取出
+	if joined, ok := err.(interface{ Unwrap() []error }); !ok || len(joined.Unwrap()) != len(want) {
+		t.Fatalf("not a joined error: %T", err)
+	}
+
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("default config: %v", err)
+	}
+}
+
+// 服务端不检查负载均衡策略有没有注册（服务端不import自定义的balancer），客户端检查
+func TestValidateBalancerPolicy(t *testing.T) {
+	args := []string{"-balancer.policy", "not_registered"}
+	if _, err := Load(args); err != nil {
+		t.Fatalf("server side: %v", err)
+	}
+	_, err := Load(args, ForClient())
+	if err == nil || !strings.Contains(err.Error(), `balancer.policy: unknown policy "not_registered"`) {
+		t.Fatalf("client side: %v", err)
+	}
+	if _, err := Load([]string{"-balancer.policy", "round_robin"}, ForClient()); err != nil {
+		t.Fatalf("registered policy: %v", err)
+	}
+
+	// 其他的错误和策略的错误一起列出来
+	cfg := Default()
+	cfg.Client.Timeout = 0
+	cfg.Balancer.Policy = "not_registered"
+	err = cfg.ValidateClient()
+	if err == nil || !strings.Contains(err.Error(), "client.timeout") || !strings.Contains(err.Error(), "balancer.policy") {
+		t.Fatalf("ValidateClient: %v", err)
+	}
+}
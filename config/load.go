@@ -0,0 +1,220 @@
+package config
+
+/*
+ * 配置的加载：配置文件 + 环境变量 + 命令行参数
+ * 环境变量和命令行参数都是根据结构体的yaml tag自动生成的，新增配置项时只需要改结构体
+ */
+import (
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 命令行参数的简写，比如 Alias("p", "server.port")
+type Option func(*loader)
+
+func Alias(short, path string) Option {
+	return func(l *loader) {
+		l.aliases[short] = path
+	}
+}
+
+// 修改默认值，在读取配置文件之前生效，比如tls的例子默认就开启TLS
+func WithDefaults(fn func(*Config)) Option {
+	return func(l *loader) {
+		l.defaults = append(l.defaults, fn)
+	}
+}
+
+// 客户端加载配置时使用，校验时额外检查客户端用到的配置（见ValidateClient）
+func ForClient() Option {
+	return func(l *loader) {
+		l.client = true
+	}
+}
+
+type loader struct {
+	aliases  map[string]string
+	defaults []func(*Config)
+	client   bool
+}
+
+// 加载配置，args一般是os.Args[1:]
+func Load(args []string, opts ...Option) (*Config, error) {
+	l := &loader{aliases: map[string]string{}}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	cfg := Default()
+	for _, fn := range l.defaults {
+		fn(cfg)
+	}
+	fields := leafFields(reflect.ValueOf(cfg).Elem(), "")
+
+	// 先把命令行参数解析出来，值暂时只记录下来，最后再覆盖
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	configFile := fs.String("config", "", "config file (yaml or json)")
+	flagValues := map[string]string{}
+	byPath := map[string]*field{}
+	for _, f := range fields {
+		byPath[f.path] = f
+		f.define(fs, f.path, f.usage, flagValues)
+	}
+	for short, path := range l.aliases {
+		f, ok := byPath[path]
+		if !ok {
+			return nil, fmt.Errorf("alias -%v: unknown config %v", short, path)
+		}
+		f.define(fs, short, "alias of -"+path, flagValues)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// 配置文件
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return nil, fmt.Errorf("read config file: %v", err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parse config file %v: %v", *configFile, err)
+		}
+	}
+
+	// 环境变量
+	for _, f := range fields {
+		if v, ok := os.LookupEnv(f.env()); ok {
+			if err := f.set(v); err != nil {
+				return nil, fmt.Errorf("env %v: %v", f.env(), err)
+			}
+		}
+	}
+
+	// 命令行参数
+	for _, f := range fields {
+		if v, ok := flagValues[f.path]; ok {
+			if err := f.set(v); err != nil {
+				return nil, fmt.Errorf("flag -%v: %v", f.path, err)
+			}
+		}
+	}
+
+	validate := cfg.Validate
+	if l.client {
+		validate = cfg.ValidateClient
+	}
+	if err := validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// 加载配置，失败时打印错误并退出，给各个例子的main函数使用
+func MustLoad(opts ...Option) *Config {
+	cfg, err := Load(os.Args[1:], opts...)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "load config:", err)
+		os.Exit(2)
+	}
+	return cfg
+}
+
+// 结构体中的一个叶子字段
+type field struct {
+	path  string // server.port
+	usage string
+	value reflect.Value
+}
+
+// 定义命令行参数，bool类型的参数可以不带值（-server.gateway 等价于 -server.gateway=true）
+func (f *field) define(fs *flag.FlagSet, name, usage string, values map[string]string) {
+	path := f.path
+	fn := func(s string) error {
+		values[path] = s
+		return nil
+	}
+	if f.value.Kind() == reflect.Bool {
+		fs.BoolFunc(name, usage, fn)
+	} else {
+		fs.Func(name, usage, fn)
+	}
+}
+
+// GRPC_CASE_SERVER_PORT
+func (f *field) env() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(f.path, ".", "_"))
+}
+
+func (f *field) set(s string) error {
+	v := f.value
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type %v", v.Type())
+	}
+	return nil
+}
+
+// 遍历结构体，取出所有叶子字段，路径由yaml tag组成
+func leafFields(v reflect.Value, prefix string) []*field {
+	var fields []*field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		path := prefix + name
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			fields = append(fields, leafFields(fv, path+".")...)
+			continue
+		}
+		fields = append(fields, &field{path: path, usage: sf.Tag.Get("usage"), value: fv})
+	}
+	return fields
+}
@@ -0,0 +1,25 @@
+package config
+
+/*
+ * 根据TLS配置生成grpc的传输层证书，没有开启TLS时返回insecure
+ */
+import (
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// 服务端证书：自签证书 & 私钥
+func (t *TLSConfig) ServerCredentials() (credentials.TransportCredentials, error) {
+	if !t.Enabled {
+		return insecure.NewCredentials(), nil
+	}
+	return credentials.NewServerTLSFromFile(t.CertFile, t.KeyFile)
+}
+
+// 客户端证书，同时需要指定域名，如果域名错误，握手会失败
+func (t *TLSConfig) ClientCredentials() (credentials.TransportCredentials, error) {
+	if !t.Enabled {
+		return insecure.NewCredentials(), nil
+	}
+	return credentials.NewClientTLSFromFile(t.CAFile, t.ServerName)
+}
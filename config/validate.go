@@ -0,0 +1,130 @@
+package config
+
+/*
+ * 配置校验，把所有的问题一次性列出来
+ */
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/balancer"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(path, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%v: %v", path, fmt.Sprintf(format, args...)))
+	}
+
+	if n, err := strconv.Atoi(c.Server.Port); err != nil || n < 0 || n > 65535 {
+		add("server.port", "must be a number between 0 and 65535, got %q", c.Server.Port)
+	}
+	if a := c.Server.Advertise; a != "" {
+		if _, p, err := net.SplitHostPort(a); err == nil {
+			if n, err := strconv.Atoi(p); err != nil || n < 0 || n > 65535 {
+				add("server.advertise", "invalid port in %q", a)
+			}
+		} else if strings.ContainsAny(a, "/ ") {
+			add("server.advertise", "must be host or host:port, got %q", a)
+		}
+	}
+	if c.Server.Name == "" {
+		add("server.name", "must not be empty")
+	} else if strings.ToLower(c.Server.Name) != c.Server.Name {
+		// 见common/define.go里的说明，service name作为URL的path，不能有大写字母
+		add("server.name", "must be lower case, got %q", c.Server.Name)
+	}
+	if c.Server.DrainDelay < 0 {
+		add("server.drain_delay", "must not be negative")
+	}
+	if c.Server.MinDeadlineBudget < 0 {
+		add("server.min_deadline_budget", "must not be negative")
+	}
+	for path, d := range map[string]time.Duration{
+		"server.keepalive.time":                     c.Server.Keepalive.Time,
+		"server.keepalive.timeout":                  c.Server.Keepalive.Timeout,
+		"server.keepalive.min_time":                 c.Server.Keepalive.MinTime,
+		"server.keepalive.max_connection_idle":      c.Server.Keepalive.MaxConnectionIdle,
+		"server.keepalive.max_connection_age":       c.Server.Keepalive.MaxConnectionAge,
+		"server.keepalive.max_connection_age_grace": c.Server.Keepalive.MaxConnectionAgeGrace,
+	} {
+		if d < 0 {
+			add(path, "must not be negative")
+		}
+	}
+	for path, n := range map[string]int{
+		"server.max_concurrent_streams": c.Server.MaxConcurrentStreams,
+		"server.max_recv_msg_size":      c.Server.MaxRecvMsgSize,
+		"server.max_send_msg_size":      c.Server.MaxSendMsgSize,
+		"server.max_connections":        c.Server.MaxConnections,
+	} {
+		if n < 0 {
+			add(path, "must not be negative")
+		}
+	}
+
+	if c.Client.Target == "" {
+		add("client.target", "must not be empty")
+	}
+	if c.Client.Timeout <= 0 {
+		add("client.timeout", "must be positive, got %v", c.Client.Timeout)
+	}
+	if c.Client.DeadlineMargin < 0 {
+		add("client.deadline_margin", "must not be negative, got %v", c.Client.DeadlineMargin)
+	}
+
+	if c.TLS.Enabled {
+		for path, file := range map[string]string{
+			"tls.cert_file": c.TLS.CertFile,
+			"tls.key_file":  c.TLS.KeyFile,
+			"tls.ca_file":   c.TLS.CAFile,
+		} {
+			if file == "" {
+				add(path, "required when tls.enabled is true")
+			} else if _, err := os.Stat(file); err != nil {
+				add(path, "%v", err)
+			}
+		}
+	}
+
+	if c.Server.Gateway && c.TLS.Enabled && c.Server.GatewayAddr == "" {
+		add("server.gateway_addr", "required when both server.gateway and tls.enabled are true")
+	}
+
+	if (c.Auth.AppId == "") != (c.Auth.AppKey == "") {
+		add("auth", "app_id and app_key must be set together")
+	}
+
+	if c.Registry.Scheme == "" || strings.ToLower(c.Registry.Scheme) != c.Registry.Scheme {
+		add("registry.scheme", "must be non-empty and lower case, got %q", c.Registry.Scheme)
+	}
+	if len(c.Registry.Endpoints) == 0 {
+		add("registry.endpoints", "must not be empty")
+	}
+	if c.Registry.DialTimeout <= 0 {
+		add("registry.dial_timeout", "must be positive, got %v", c.Registry.DialTimeout)
+	}
+	if c.Registry.LeaseTTL <= 0 {
+		add("registry.lease_ttl", "must be positive, got %v", c.Registry.LeaseTTL)
+	}
+
+	if c.Balancer.Policy == "" {
+		add("balancer.policy", "must not be empty")
+	}
+
+	return errors.Join(errs...)
+}
+
+// 客户端额外的校验：负载均衡策略必须已经注册过（自定义的balancer需要先import对应的包）
+// 服务端用不到balancer.policy，也不会import自定义的balancer，所以不放在Validate中
+func (c *Config) ValidateClient() error {
+	err := c.Validate()
+	if c.Balancer.Policy != "" && balancer.Get(c.Balancer.Policy) == nil {
+		err = errors.Join(err, fmt.Errorf("balancer.policy: unknown policy %q", c.Balancer.Policy))
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+/**
+ * 地址上附带的属性（权重、元数据）
+ * resolver把属性写到resolver.Address.BalancerAttributes中，balancer再从中取出来使用，
+ * 放在BalancerAttributes而不是Attributes中，属性变化时不会重建subConn
+ * 优先级例外，放在Attributes中（key为PriorityKey），优先级不同的地址看作不同的实例
+ */
+package attributes
+
+import (
+	"encoding/json"
+	"google.golang.org/grpc/resolver"
+)
+
+type weightKey struct{}
+type metadataKey struct{}
+
+// 地址的优先级在resolver.Address.Attributes中的key，值为uint32，数值越小越优先（和DNS SRV记录的含义一致）
+type PriorityKey struct{}
+
+// 默认权重
+const DefaultWeight uint32 = 1
+
+// 设置地址的权重
+func SetWeight(addr resolver.Address, weight uint32) resolver.Address {
+	addr.BalancerAttributes = addr.BalancerAttributes.WithValue(weightKey{}, weight)
+	return addr
+}
+
+// 取出地址的权重，没有设置（或者为0）时返回DefaultWeight
+func Weight(addr resolver.Address) uint32 {
+	if w, ok := addr.BalancerAttributes.Value(weightKey{}).(uint32); ok && w > 0 {
+		return w
+	}
+	return DefaultWeight
+}
+
+// 设置地址的优先级
+func SetPriority(addr resolver.Address, priority uint32) resolver.Address {
+	addr.Attributes = addr.Attributes.WithValue(PriorityKey{}, priority)
+	return addr
+}
+
+// 取出地址的优先级，没有设置时返回0（最优先）
+func Priority(addr resolver.Address) uint32 {
+	p, _ := addr.Attributes.Value(PriorityKey{}).(uint32)
+	return p
+}
+
+// 设置地址的元数据，比如机房、版本等
+func SetMetadata(addr resolver.Address, md Metadata) resolver.Address {
+	addr.BalancerAttributes = addr.BalancerAttributes.WithValue(metadataKey{}, md)
+	return addr
+}
+
+// 取出地址的元数据，没有设置时返回nil
+func GetMetadata(addr resolver.Address) Metadata {
+	md, _ := addr.BalancerAttributes.Value(metadataKey{}).(Metadata)
+	return md
+}
+
+// 元数据，实现Equal方法，attributes比较的时候会用到
+type Metadata map[string]string
+
+func (m Metadata) Equal(o any) bool {
+	other, ok := o.(Metadata)
+	if !ok || len(m) != len(other) {
+		return false
+	}
+	for k, v := range m {
+		if ov, ok := other[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+// 服务发现中的一个实例：地址，以及可选的权重和属性
+// JSON格式：{"addr": "127.0.0.1:9091", "weight": 3, "attributes": {"zone": "b"}}，也可以直接写成地址字符串 "127.0.0.1:9090"
+type Endpoint struct {
+	Addr       string            `json:"addr"`
+	Weight     uint32            `json:"weight,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+func (e *Endpoint) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &e.Addr)
+	}
+	type plain Endpoint
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+// 转成grpc的地址，权重和属性写到BalancerAttributes中
+func (e Endpoint) Address() resolver.Address {
+	addr := resolver.Address{Addr: e.Addr}
+	if e.Weight > 0 {
+		addr = SetWeight(addr, e.Weight)
+	}
+	if len(e.Attributes) > 0 {
+		addr = SetMetadata(addr, e.Attributes)
+	}
+	return addr
+}
+
+// 两个实例是否相同
+func (e Endpoint) Equal(o Endpoint) bool {
+	return e.Addr == o.Addr && e.Weight == o.Weight && Metadata(e.Attributes).Equal(Metadata(o.Attributes))
+}
@@ -11,13 +11,17 @@
  *  is built for each ClientConn. The Resolver will watch the updates for the
  *  target, and send updates to the ClientConn.
  */
-package main
+// Note：这里单独立出一个包，方便客户端和命令行工具都能导入
+package resolver
 
 import (
-	"encoding/json"
-	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
 	"google.golang.org/grpc/resolver"
 	"grpc-case/common"
+	"grpc-case/logging"
+	"grpc-case/metrics"
+	"grpc-case/tracing"
 )
 
 // 将自定义的Resolver注册到grpc中
@@ -27,6 +31,11 @@ func init() {
 	resolver.Register(&myBuilder{})
 }
 
+// 可以通过logging.SetNamed("resolver.basic", ...)注入logger
+func log() *zap.Logger {
+	return logging.Named("resolver.basic")
+}
+
 // 业务自己的Builder，实现resolver.ResolverBuilder接口
 // 这个Builder将会被注册到resolver包当中，它的作用是用来生成业务自己的Resolver
 type myBuilder struct {
@@ -48,8 +57,7 @@ type myResolver struct {
 // 创建并返回业务自己的Resolver实例
 // 注意这里一个Builder可以生成多种Resolver，主要取决于target的不同
 func (*myBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
-	s, _ := json.Marshal(target.URL)
-	fmt.Printf("Call--------Build. Parsed Target.URL: %v\n", string(s))
+	log().Info("build", zap.String("target", target.URL.String()))
 
 	// 这里为了简便，直接创建Resolver
 	// Note： 实际上，应该是根据不同的target，生成不用的Resolver
@@ -73,13 +81,16 @@ func (*myBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts res
 
 // 触发解析的逻辑
 func (r *myResolver) ResolveNow(o resolver.ResolveNowOptions) {
-	fmt.Println("Call--------ResolveNow-----Endpoint:", r.target.Endpoint())
+	log().Debug("resolve now", zap.String("service", r.target.Endpoint()))
 	// 直接从map中取出对应的addrList
 	addrStrs := r.addrsMap[r.target.Endpoint()] // Endpoint()其实就是
 	instanceList := make([]resolver.Address, len(addrStrs))
 	for i, s := range addrStrs {
 		instanceList[i] = resolver.Address{Addr: s}
 	}
+	metrics.ResolverAddresses.WithLabelValues(common.MyScheme, r.target.Endpoint()).Set(float64(len(instanceList)))
+	tracing.RecordEvent("resolver.UpdateState", "addresses updated",
+		attribute.String("target", r.target.URL.String()), attribute.StringSlice("addrs", addrStrs))
 
 	// 更新连接状态信息，即把从路由表中查到的 addrs 更新到底层的 connection 中.
 	r.cc.UpdateState(resolver.State{Addresses: instanceList})
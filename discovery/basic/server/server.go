@@ -7,25 +7,26 @@ package main
 
 import (
 	"context"
-	"flag"
-	"fmt"
-	"google.golang.org/grpc"
-	"grpc-case/common"
+	"go.uber.org/zap"
+	"grpc-case/bootstrap"
+	"grpc-case/config"
+	"grpc-case/logging"
+	"grpc-case/metrics"
 	"grpc-case/pb"
+	"grpc-case/tracing"
 	"net"
 	"sync"
 )
 
-var portStr *string = flag.String("p", common.BackEndPort0, "port")
-
 // 业务自己的Server，实现各个服务端方法
 type MyServer struct {
 	pb.UnimplementedHelloServiceServer //首先包装一个UnimplementedServer，使自身成为HelloServiceServer接口的实现
+	port                               string
 }
 
 // 实现业务代码
 func (m *MyServer) SayHello(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
-	fmt.Printf("Server[%v] Recv Request:%v\n", *portStr, req.Name)
+	logging.WithContext(ctx).Info("recv request", zap.String("port", m.port), zap.String("name", req.Name))
 	return &pb.HelloReply{
 		Message: "Hello " + req.Name,
 	}, nil
@@ -38,26 +39,43 @@ func (m *MyServer) SayHello(ctx context.Context, req *pb.HelloRequest) (*pb.Hell
 
 // 服务启动起来
 func main() {
-	// 参数解析
-	flag.Parse()
+	// 加载配置
+	cfg := config.MustLoad(config.Alias("p", "server.port"), config.Alias("metrics", "server.metrics_addr"))
+
+	// 链路追踪，是否导出由环境变量OTEL_TRACES_EXPORTER决定，每个进程初始化一次
+	shutdownTracing, err := tracing.Init(context.Background(), "grpc-case")
+	if err != nil {
+		panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// 暴露监控指标
+	if cfg.Server.MetricsAddr != "" {
+		if _, err := metrics.Serve(cfg.Server.MetricsAddr); err != nil {
+			panic(err)
+		}
+	}
 
 	// 创建监听端口
-	listener, err := net.Listen("tcp", ":"+*portStr)
+	listener, err := net.Listen("tcp", cfg.Server.ListenAddr())
 	if err != nil {
 		panic(err)
 	}
 
 	// 创建grpc服务
-	grpcServer := grpc.NewServer()
+	grpcServer, err := bootstrap.NewServerFromConfig(cfg)
+	if err != nil {
+		panic(err)
+	}
 
 	// 在grpc服务中，注册业务自己的服务（也就是将自己的Server对象与grpc服务绑定）
-	pb.RegisterHelloServiceServer(grpcServer, &MyServer{})
+	pb.RegisterHelloServiceServer(grpcServer, &MyServer{port: cfg.Server.Port})
 
 	// 启动grpc服务
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
-		fmt.Printf("Server Start! Port:%v\n", *portStr)
+		logging.L().Info("server start", zap.String("port", cfg.Server.Port))
 		defer wg.Done()
 		err = grpcServer.Serve(listener)
 		if err != nil {
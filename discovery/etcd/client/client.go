@@ -7,23 +7,59 @@ import (
 	"context"
 	"fmt"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/balancer/roundrobin"
 	"google.golang.org/grpc/credentials/insecure"
+	_ "google.golang.org/grpc/health" // 开启客户端健康检查
 	"grpc-case/common"
-	_ "grpc-case/discovery/etcd/client/resolver" // 这个很重要，注册基于etcd的resolver
+	"grpc-case/config"
+	"grpc-case/discovery/etcd/client/resolver" // 这个很重要，注册基于etcd的resolver
+	"grpc-case/logging"
+	"grpc-case/metrics"
+	"grpc-case/middleware"
 	"grpc-case/pb"
+	"grpc-case/tracing"
 	"time"
 )
 
-// 启动go run client.go
+// 启动go run client.go -metrics :9290
 func main() {
+	// 加载配置，这个例子默认访问etcd:///myservicename_etcd
+	cfg := config.MustLoad(
+		config.ForClient(),
+		config.Alias("target", "client.target"),
+		config.Alias("lb", "balancer.policy"),
+		config.Alias("metrics", "client.metrics_addr"),
+		config.WithDefaults(func(c *config.Config) { c.Client.Target = common.AddressEtcd }),
+	)
+	resolver.Configure(cfg.Registry.Endpoints, cfg.Registry.DialTimeout)
+
+	// 暴露监控指标
+	if cfg.Client.MetricsAddr != "" {
+		if _, err := metrics.Serve(cfg.Client.MetricsAddr); err != nil {
+			panic(err)
+		}
+	}
+
+	// 链路追踪，是否导出由环境变量OTEL_TRACES_EXPORTER决定
+	shutdown, err := tracing.Init(context.Background(), "grpc-case-client")
+	if err != nil {
+		panic(err)
+	}
+	defer shutdown(context.Background())
+
 	// 访问服务端address,创建连接conn,地址格式 myScheme:///myServiceName
 	// 函数中会先根据myScheme这个scheme找到我们通过init函数注册的myBuilder，
 	// 然后调用它的Build()方法构建我们自定义的myResolver，并调用ResolveNow()方法获取到服务端地址
-	conn, err := grpc.NewClient(common.AddressEtcd,
+	conn, err := grpc.NewClient(cfg.Client.Target,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		tracing.DialOption(), // 链路追踪
+		grpc.WithChainUnaryInterceptor( // 请求ID、日志、监控、超时拦截器
+			middleware.RequestIdUnaryClientInterceptor(),
+			logging.UnaryClientInterceptor(logging.L()),
+			metrics.UnaryClientInterceptor(),
+			middleware.DeadlineUnaryClientInterceptor(cfg.Client.DeadlineMargin, cfg.Client.Timeout),
+		),
 		grpc.WithDefaultServiceConfig( // Note: 这里是在指定负载均衡的策略，如果不指定，则默认只会调用一个服务端实例，除非实例挂了才会切换
-			fmt.Sprintf(`{"loadBalancingPolicy":"%v"}`, roundrobin.Name)),
+			common.GenServiceConfig(cfg.Balancer.Policy)),
 	)
 	if err != nil {
 		panic(err)
@@ -35,8 +71,8 @@ func main() {
 
 	// 执行RPC调用
 	for i := 0; i < 300; i++ {
-		// 设置客户端访问超时时间1秒
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		// 设置客户端访问超时时间（默认1秒）
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Client.Timeout)
 		defer cancel()
 		rsp, err := client.SayHello(ctx, &pb.HelloRequest{
 			Name: "msg_" + fmt.Sprintf("%v", i),
@@ -10,19 +10,35 @@
  *  ResolverBuilder will be picked to build a Resolver. Note that a new Resolver
  *  is built for each ClientConn. The Resolver will watch the updates for the
  *  target, and send updates to the ClientConn.
+ *
+ * 滚动重启时短时间内会有大量的上下线事件，每次都推给grpc的话balancer会不停地重建picker，
+ * 所以新增的实例在DebounceWindow内合并成一次推送，持续有变化时最多推迟DebounceMaxDelay；
+ * 实例下线会立即推送（连同之前积攒的变化），免得请求继续发往已经停掉的实例
+ *
+ * 保护模式（panic threshold）：etcd中的key被大量删除（租约风暴、误删）时，实例数量突然低于上一次正常列表的PanicThreshold比例，
+ * 继续使用上一次正常的列表PanicHoldTime，同时上报resolver_panic_mode指标；超时之后仍然没有恢复，才接受新的列表
  */
 // Note：这里单独立出一个包，因为balancer也会需要导入
 package resolver
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
-	etcdCLientv3 "go.etcd.io/etcd/client/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
 	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
 	"grpc-case/common"
+	"grpc-case/discovery/attributes"
+	"grpc-case/logging"
+	"grpc-case/metrics"
+	"grpc-case/tracing"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -31,24 +47,79 @@ import (
 // 将自定义的Resolver注册到grpc中
 // 注意这里不是直接注册Resolver，而是注册的Builder，而Builder负责创建Resolver
 // Builder有一个Scheme方法，用来指定自身的Key（grpc内部有Map[scheme]=>Builder）
+// etcd客户端在第一次Build的时候才创建，地址通过Configure指定（不指定则使用common中的默认地址）
+// 也可以在target的authority中指定etcd地址，比如每个机房一套etcd：etcd://10.0.0.1:2379,10.0.0.2:2379/myservicename_etcd
 func init() {
-	etcdCli, err := etcdCLientv3.New(etcdCLientv3.Config{
-		Endpoints:   []string{common.EtcdAddr},
-		DialTimeout: common.EtcdTimeout * time.Second,
-	})
-	if err != nil {
-		panic(err)
-	}
+	resolver.Register(defaultBuilder)
+}
 
-	resolver.Register(&etcdBuilder{
-		client: etcdCli,
-	})
+var (
+	// 合并地址更新的窗口，窗口内的多次变化只推送一次，0表示不合并，需要在创建连接之前设置
+	DebounceWindow = 100 * time.Millisecond
+	// 从第一次变化开始最多推迟多久，避免持续有变化时一直推不出去
+	DebounceMaxDelay = time.Second
+	// 实例数量低于上一次正常列表的多少比例时进入保护模式，比如0.5；0表示关闭
+	PanicThreshold float64
+	// 保护模式最多持续多久
+	PanicHoldTime = 30 * time.Second
+)
+
+var defaultBuilder = &etcdBuilder{
+	endpoints:   []string{common.EtcdAddr},
+	dialTimeout: common.EtcdTimeout * time.Second,
+}
+
+// 指定etcd的地址，需要在创建连接之前调用
+func Configure(endpoints []string, dialTimeout time.Duration) {
+	defaultBuilder.mu.Lock()
+	defer defaultBuilder.mu.Unlock()
+	defaultBuilder.endpoints = endpoints
+	defaultBuilder.dialTimeout = dialTimeout
+}
+
+// 使用指定的etcd客户端创建Builder，通过grpc.WithResolvers只给某个连接使用，不影响全局注册的Builder
+// 比如测试中使用内存实现的etcd（见testutil.Registry）
+func NewBuilder(client *clientv3.Client) resolver.Builder {
+	return &etcdBuilder{clients: map[string]*clientv3.Client{"": client}}
+}
+
+// 可以通过logging.SetNamed("resolver.etcd", ...)注入logger
+func log() *zap.Logger {
+	return logging.Named("resolver.etcd")
 }
 
 // 业务自己的Builder，实现resolver.ResolverBuilder接口
 // 这个Builder将会被注册到resolver包当中，它的作用是用来生成业务自己的Resolver
 type etcdBuilder struct {
-	client *clientv3.Client
+	mu          sync.Mutex
+	endpoints   []string
+	dialTimeout time.Duration
+	clients     map[string]*clientv3.Client // authority => client，""表示Configure指定的默认地址
+}
+
+// 取得etcd客户端，没有的话先创建，同一个authority共用一个客户端
+func (eb *etcdBuilder) getClient(authority string) (*clientv3.Client, error) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	if c, ok := eb.clients[authority]; ok {
+		return c, nil
+	}
+	endpoints := eb.endpoints
+	if authority != "" {
+		endpoints = strings.Split(authority, ",")
+	}
+	etcdCli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: eb.dialTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if eb.clients == nil {
+		eb.clients = map[string]*clientv3.Client{}
+	}
+	eb.clients[authority] = etcdCli
+	return etcdCli, nil
 }
 
 // 用来被注册的时候，生成key
@@ -58,127 +129,374 @@ func (*etcdBuilder) Scheme() string {
 
 // 创建并返回业务自己的Resolver实例
 func (eb *etcdBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
-	s, _ := json.Marshal(target.URL)
-	fmt.Printf("Call--------Build. Parsed Target.URL: %v\n", string(s))
 	targetName := target.Endpoint()
-	fmt.Printf("targetName------- %v\n", targetName)
-	watchPath := common.GenBasePath(common.MySchemeEtcd, targetName)
-	// 初始化先读取路径下的配置
-	var initAddrs []string
-	getResp, err := eb.client.Get(context.Background(), watchPath, clientv3.WithPrefix())
+	log().Info("build", zap.String("target", target.URL.String()), zap.String("service", targetName))
+	client, err := eb.getClient(target.URL.Host)
 	if err != nil {
 		return nil, err
-	} else {
-		for _, kv := range getResp.Kvs {
-			fmt.Println("X----------------", string(kv.Key), "=>", string(kv.Value))
-			initAddrs = append(initAddrs, string(kv.Value))
-		}
 	}
 
 	// 这里为了简便，直接创建Resolver
+	// 前缀带上结尾的/，免得myservicename_etcd匹配到myservicename_etcd2下面的实例
+	ctx, cancel := context.WithCancel(context.Background())
 	myResolver := &etcdResolver{
-		target: target,
-		cc:     cc,
-		addrsMap: map[string][]string{
-			targetName: initAddrs,
-		},
+		target:     target,
+		cc:         cc,
+		client:     client,
+		prefix:     common.GenBasePath(common.MySchemeEtcd, targetName) + "/",
+		configPath: common.GenConfigPath(common.MySchemeEtcd, targetName),
+		instances:  map[string]attributes.Endpoint{},
+		window:     DebounceWindow,
+		maxDelay:   DebounceMaxDelay,
+		threshold:  PanicThreshold,
+		holdTime:   PanicHoldTime,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+
+	// 初始化先读取路径下的全部实例和配置
+	rev, err := myResolver.sync()
+	if err != nil {
+		cancel()
+		return nil, err
 	}
 	// 强制触发一次更新
 	myResolver.ResolveNow(resolver.ResolveNowOptions{})
 
-	// 触发一个watcher goroutine，从etcd里面定时更新服务列表到addrsMap
-	go func() {
-		//cctx, cancel := context.WithTimeout(context.TODO(), 5*time.Minute)
-		//defer cancel()
-		fmt.Println("Watch--------", watchPath)
-		rch := eb.client.Watch(context.Background(), watchPath, clientv3.WithPrefix(), clientv3.WithRev(getResp.Header.Revision))
+	// 触发一个watcher goroutine，从etcd里面增量更新实例
+	go myResolver.watch(rev)
+
+	return myResolver, nil
+}
+
+// 业务自己的Resolver，实现resolver.Resolver接口
+// 这个Resolver是真正负责维护服务端地址列表的，用于将服务名解析成对应实例列表
+type etcdResolver struct {
+	target        resolver.Target
+	cc            resolver.ClientConn
+	client        *clientv3.Client
+	prefix        string                         // /etcd/myservicename_etcd/
+	configPath    string                         // /etcd/myservicename_etcd/__config__
+	instances     map[string]attributes.Endpoint // etcd key => 实例
+	serviceConfig *serviceconfig.ParseResult     // etcd中的动态配置，nil表示没有配置，使用客户端的默认配置（WithDefaultServiceConfig）
+	mu            sync.RWMutex
+	updateMu      sync.Mutex // 保证UpdateState的顺序
+
+	// 合并推送
+	window       time.Duration
+	maxDelay     time.Duration
+	debounceMu   sync.Mutex
+	debounce     *time.Timer // 等待中的推送，nil表示没有
+	pendingSince time.Time   // 第一个未推送的变化的时间
+
+	// 保护模式，由updateMu保护
+	threshold  float64
+	holdTime   time.Duration
+	lastGood   []resolver.Address // 上一次正常推送的地址
+	panicSince time.Time          // 进入保护模式的时间，零值表示不在保护模式
+	panicTimer *time.Timer        // 保护模式到期后重新推送
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// 全量读取前缀下的实例和配置，替换掉本地的数据，返回读取时的revision
+func (r *etcdResolver) sync() (int64, error) {
+	getResp, err := r.client.Get(r.ctx, r.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+	instances := map[string]attributes.Endpoint{}
+	var config []byte
+	for _, kv := range getResp.Kvs {
+		key := string(kv.Key)
+		if key == r.configPath {
+			config = kv.Value
+			continue
+		}
+		e, err := parseInstance(kv.Value)
+		if err != nil {
+			log().Warn("invalid instance, ignored", zap.String("key", key), zap.Error(err))
+			continue
+		}
+		log().Debug("init instance", zap.String("key", key), zap.String("addr", e.Addr))
+		instances[key] = e
+	}
+	r.setServiceConfig(config)
+	r.mu.Lock()
+	r.instances = instances
+	r.mu.Unlock()
+	return getResp.Header.Revision, nil
+}
+
+// 从rev之后开始监听变化；watch被压缩（compaction）或者中断时，重新全量读取之后再继续监听
+func (r *etcdResolver) watch(rev int64) {
+	targetName := r.target.Endpoint()
+	var retries int
+	for r.ctx.Err() == nil {
+		log().Info("watch", zap.String("path", r.prefix), zap.Int64("rev", rev+1))
+		rch := r.client.Watch(r.ctx, r.prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1))
 		for n := range rch {
-			var needRefresh bool
+			if err := n.Err(); err != nil {
+				// 需要的revision已经被压缩掉了，中间的事件拿不到，只能全量同步
+				log().Warn("watch failed, resync", zap.String("path", r.prefix),
+					zap.Int64("compactRevision", n.CompactRevision), zap.Error(err))
+				break
+			}
+			var needRefresh, removed bool
 			for _, ev := range n.Events {
-				fmt.Printf("receive etcd event %v\n", ev)
-				switch ev.Type {
-				case mvccpb.PUT:
-					addr := string(ev.Kv.Value)
-					myResolver.mu.Lock()
-					if !exist(myResolver.addrsMap[targetName], addr) {
-						myResolver.addrsMap[targetName] = append(myResolver.addrsMap[targetName], addr)
-						needRefresh = true
-						fmt.Println("新增地址：", addr)
-					}
-					myResolver.mu.Unlock()
-				case mvccpb.DELETE:
-					path := string(ev.Kv.Key) // 删除仅能得到key
-					tmp := strings.Split(path, "/")
-					if len(tmp) == 0 {
-						panic("wrong path")
-					}
-					addr := tmp[len(tmp)-1]
-					myResolver.mu.Lock()
-					if exist(myResolver.addrsMap[targetName], addr) {
-						myResolver.addrsMap[targetName] = remove(myResolver.addrsMap[targetName], addr)
-						needRefresh = true
-						fmt.Println("删除地址：", addr)
-					}
-					myResolver.mu.Unlock()
+				log().Debug("etcd event", zap.String("type", ev.Type.String()), zap.String("key", string(ev.Kv.Key)))
+				metrics.ResolverWatchEvents.WithLabelValues(targetName, ev.Type.String()).Inc()
+				if r.apply(ev) {
+					needRefresh = true
+					removed = removed || ev.Type == mvccpb.DELETE
 				}
 			}
-			// 触发地址更新
+			rev = n.Header.Revision
+			// 触发地址更新，有实例下线时立即推送，否则合并之后再推送
 			if needRefresh {
-				myResolver.ResolveNow(resolver.ResolveNowOptions{})
+				r.scheduleUpdate(removed)
 			}
 		}
-	}()
+		if r.ctx.Err() != nil {
+			return
+		}
 
-	return myResolver, nil
+		// 全量同步，失败的话退避重试
+		metrics.ResolverWatchEvents.WithLabelValues(targetName, "RESYNC").Inc()
+		for {
+			newRev, err := r.sync()
+			if err == nil {
+				retries = 0
+				rev = newRev
+				r.scheduleUpdate(true)
+				break
+			}
+			wait := common.Backoff(retries, time.Second, 30*time.Second)
+			retries++
+			log().Warn("resync failed", zap.String("path", r.prefix), zap.Duration("backoff", wait), zap.Error(err))
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}
 }
 
-// 业务自己的Resolver，实现resolver.Resolver接口
-// 这个Resolver是真正负责维护服务端地址列表的，用于将服务名解析成对应实例列表
-type etcdResolver struct {
-	target   resolver.Target
-	cc       resolver.ClientConn
-	addrsMap map[string][]string // serviceName => []backendIpPort
-	mu       sync.RWMutex
+// 处理一个watch事件，返回地址列表是否有变化
+func (r *etcdResolver) apply(ev *clientv3.Event) bool {
+	key := string(ev.Kv.Key)
+	// 服务的动态配置，和实例在同一个目录下
+	if key == r.configPath {
+		if ev.Type == mvccpb.PUT {
+			r.setServiceConfig(ev.Kv.Value)
+		} else {
+			r.setServiceConfig(nil)
+		}
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch ev.Type {
+	case mvccpb.PUT:
+		e, err := parseInstance(ev.Kv.Value)
+		if err != nil {
+			log().Warn("invalid instance, ignored", zap.String("key", key), zap.Error(err))
+			return false
+		}
+		// 同一个key再次写入（比如续租之后重新注册、修改了权重），原地更新
+		if old, ok := r.instances[key]; ok && old.Equal(e) {
+			return false
+		}
+		r.instances[key] = e
+		log().Info("add address", zap.String("key", key), zap.String("addr", e.Addr))
+		return true
+	case mvccpb.DELETE:
+		// 删除仅能得到key，按key精确删除
+		e, ok := r.instances[key]
+		if !ok {
+			return false
+		}
+		delete(r.instances, key)
+		log().Info("remove address", zap.String("key", key), zap.String("addr", e.Addr))
+		return true
+	}
+	return false
 }
 
-// 触发解析的逻辑
-// Note: 公司的线上框架，这个函数啥也不干，只通过异步的goroutine来调用r.cc.UpdateState
-func (r *etcdResolver) ResolveNow(o resolver.ResolveNowOptions) {
-	fmt.Println("Call--------ResolveNow")
-	// 直接从map中取出对应的addrList
+// 解析实例，值可以直接是地址（127.0.0.1:9090），也可以是带权重和属性的JSON
+func parseInstance(value []byte) (attributes.Endpoint, error) {
+	var e attributes.Endpoint
+	v := bytes.TrimSpace(value)
+	if len(v) > 0 && v[0] == '{' {
+		if err := json.Unmarshal(v, &e); err != nil {
+			return e, err
+		}
+	} else {
+		e.Addr = string(v)
+	}
+	if e.Addr == "" {
+		return e, errors.New("empty addr")
+	}
+	return e, nil
+}
+
+// 解析etcd中的服务配置（JSON格式，和WithDefaultServiceConfig相同），data为空表示删除
+// 解析失败时保留之前的配置，免得一个写错的配置让所有请求都失败
+// Note: 配置删除之后grpc会继续使用最后一次的配置，想恢复默认配置需要把默认配置put回去
+func (r *etcdResolver) setServiceConfig(data []byte) {
+	var sc *serviceconfig.ParseResult
 	r.mu.RLock()
-	addrStrs := r.addrsMap[r.target.Endpoint()] // 这里其实就是path: myservicename_etcd
-	if len(addrStrs) == 0 {
+	unchanged := len(data) == 0 && r.serviceConfig == nil
+	r.mu.RUnlock()
+	if unchanged {
 		return
 	}
+	if len(data) > 0 {
+		sc = r.cc.ParseServiceConfig(string(data))
+		if sc.Err != nil {
+			log().Warn("invalid service config, ignored", zap.String("service", r.target.Endpoint()), zap.Error(sc.Err))
+			return
+		}
+		log().Info("service config updated", zap.String("service", r.target.Endpoint()), zap.ByteString("config", data))
+	} else {
+		log().Info("service config removed", zap.String("service", r.target.Endpoint()))
+	}
+	r.mu.Lock()
+	r.serviceConfig = sc
+	r.mu.Unlock()
+}
+
+// 触发解析的逻辑
+// Note: 公司的线上框架，这个函数啥也不干，只通过异步的goroutine来调用r.cc.UpdateState
+// grpc和watch goroutine都会调用这里，updateMu保证取快照和推送是一个整体，旧的地址列表不会覆盖新的
+func (r *etcdResolver) ResolveNow(o resolver.ResolveNowOptions) {
+	log().Debug("resolve now", zap.String("service", r.target.Endpoint()))
+	r.updateMu.Lock()
+	defer r.updateMu.Unlock()
 
-	instanceList := make([]resolver.Address, len(addrStrs))
-	for i, s := range addrStrs {
-		instanceList[i] = resolver.Address{Addr: s}
+	instanceList, serviceConfig := r.snapshot()
+	instanceList = r.guard(instanceList)
+	addrStrs := make([]string, len(instanceList))
+	for i, a := range instanceList {
+		addrStrs[i] = a.Addr
 	}
-	r.mu.RUnlock()
+	metrics.ResolverAddresses.WithLabelValues(common.MySchemeEtcd, r.target.Endpoint()).Set(float64(len(instanceList)))
+	tracing.RecordEvent("resolver.UpdateState", "addresses updated",
+		attribute.String("target", r.target.URL.String()), attribute.StringSlice("addrs", addrStrs))
 
 	// 更新连接状态信息，即把从路由表中查到的 addrs 更新到底层的 connection 中.
-	r.cc.UpdateState(resolver.State{Addresses: instanceList})
+	// 同时带上动态配置，负载均衡策略、重试、超时等可以在不重建连接的情况下切换
+	// 没有实例时也要推送空列表，让grpc关掉已有的连接，再通过ReportError给出明确的原因，请求会立即失败而不是一直等待
+	r.cc.UpdateState(resolver.State{Addresses: instanceList, ServiceConfig: serviceConfig})
+	if len(instanceList) == 0 {
+		r.cc.ReportError(fmt.Errorf("etcd resolver: no instances of %q under %v", r.target.Endpoint(), r.prefix))
+	}
 }
 
-func (*etcdResolver) Close() {}
-
-func exist(l []string, addr string) bool {
-	for _, v := range l {
-		if v == addr {
-			return true
+// 保护模式的判断，返回实际要推送的地址，需要持有updateMu
+// 新的列表低于上一次正常列表的threshold比例时，holdTime之内继续使用上一次正常的列表
+func (r *etcdResolver) guard(instanceList []resolver.Address) []resolver.Address {
+	service := r.target.Endpoint()
+	if r.threshold <= 0 || float64(len(instanceList)) >= r.threshold*float64(len(r.lastGood)) {
+		if !r.panicSince.IsZero() {
+			log().Info("leave panic mode", zap.String("service", service), zap.Int("addrs", len(instanceList)))
+			metrics.ResolverPanicMode.WithLabelValues(common.MySchemeEtcd, service).Set(0)
 		}
+		r.lastGood, r.panicSince = instanceList, time.Time{}
+		return instanceList
 	}
-	return false
+
+	now := time.Now()
+	if r.panicSince.IsZero() {
+		r.panicSince = now
+		log().Warn("enter panic mode, keep last good addresses", zap.String("service", service),
+			zap.Int("addrs", len(instanceList)), zap.Int("lastGood", len(r.lastGood)), zap.Duration("hold", r.holdTime))
+		metrics.ResolverPanicMode.WithLabelValues(common.MySchemeEtcd, service).Set(1)
+	}
+	left := r.holdTime - now.Sub(r.panicSince)
+	if left <= 0 {
+		// 超时仍然没有恢复，认为实例确实减少了
+		log().Warn("panic mode expired, accept new addresses", zap.String("service", service), zap.Int("addrs", len(instanceList)))
+		metrics.ResolverPanicMode.WithLabelValues(common.MySchemeEtcd, service).Set(0)
+		r.lastGood, r.panicSince = instanceList, time.Time{}
+		return instanceList
+	}
+	// 到期时即使etcd没有新的事件也要重新推送一次
+	if r.panicTimer != nil {
+		r.panicTimer.Stop()
+	}
+	r.panicTimer = time.AfterFunc(left, r.flush)
+	return r.lastGood
 }
 
-func remove(s []string, addr string) []string {
-	var ret []string
-	for _, v := range s {
-		if v != addr {
-			ret = append(ret, v)
-		}
+// 安排一次地址推送，immediate为true或者没有开启合并时立即推送
+// 已经有等待中的推送时，这次变化并入其中（计入suppressed），并把推送时间往后推一个窗口，但不超过maxDelay
+func (r *etcdResolver) scheduleUpdate(immediate bool) {
+	if immediate || r.window <= 0 {
+		r.flush()
+		return
+	}
+	r.debounceMu.Lock()
+	defer r.debounceMu.Unlock()
+	if r.debounce == nil {
+		r.pendingSince = time.Now()
+		r.debounce = time.AfterFunc(r.window, r.flush)
+		return
+	}
+	metrics.ResolverSuppressedUpdates.WithLabelValues(common.MySchemeEtcd, r.target.Endpoint()).Inc()
+	wait := r.window
+	if left := r.maxDelay - time.Since(r.pendingSince); left < wait {
+		wait = left
+	}
+	r.debounce.Reset(wait)
+}
+
+// 取消等待中的推送，立即推送当前的地址列表
+func (r *etcdResolver) flush() {
+	r.debounceMu.Lock()
+	if r.debounce != nil {
+		r.debounce.Stop()
+		r.debounce = nil
+	}
+	r.debounceMu.Unlock()
+	if r.ctx.Err() != nil {
+		return
+	}
+	r.ResolveNow(resolver.ResolveNowOptions{})
+}
+
+// 取出所有实例，按key排序，保证每次的顺序一致
+func (r *etcdResolver) snapshot() ([]resolver.Address, *serviceconfig.ParseResult) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]string, 0, len(r.instances))
+	for k := range r.instances {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	instanceList := make([]resolver.Address, len(keys))
+	for i, k := range keys {
+		instanceList[i] = r.instances[k].Address()
+	}
+	return instanceList, r.serviceConfig
+}
+
+func (r *etcdResolver) Close() {
+	r.cancel()
+	r.debounceMu.Lock()
+	if r.debounce != nil {
+		r.debounce.Stop()
+		r.debounce = nil
+	}
+	r.debounceMu.Unlock()
+	r.updateMu.Lock()
+	if r.panicTimer != nil {
+		r.panicTimer.Stop()
 	}
-	return ret
+	r.updateMu.Unlock()
+	metrics.ResolverPanicMode.WithLabelValues(common.MySchemeEtcd, r.target.Endpoint()).Set(0)
 }
@@ -0,0 +1,267 @@
+package resolver_test
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"grpc-case/common"
+	etcdresolver "grpc-case/discovery/etcd/client/resolver"
+	"grpc-case/pb"
+	"grpc-case/testutil"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const service = "hello_etcd"
+
+// 记录resolver推送的地址，代替grpc的ClientConn
+type fakeCC struct {
+	resolver.ClientConn
+	mu      sync.Mutex
+	addrs   []string
+	updated chan struct{}
+	errs    atomic.Int64 // ReportError的次数
+}
+
+func newFakeCC() *fakeCC {
+	return &fakeCC{updated: make(chan struct{}, 1)}
+}
+
+func (cc *fakeCC) UpdateState(s resolver.State) error {
+	addrs := make([]string, len(s.Addresses))
+	for i, a := range s.Addresses {
+		addrs[i] = a.Addr
+	}
+	sort.Strings(addrs)
+	cc.mu.Lock()
+	cc.addrs = addrs
+	cc.mu.Unlock()
+	select {
+	case cc.updated <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (cc *fakeCC) ReportError(error) {
+	cc.errs.Add(1)
+}
+
+func (cc *fakeCC) ParseServiceConfig(string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{}
+}
+
+func (cc *fakeCC) current() []string {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.addrs
+}
+
+// 等待最近一次推送的地址等于want
+func (cc *fakeCC) wait(t *testing.T, want ...string) {
+	t.Helper()
+	sort.Strings(want)
+	timeout := time.After(5 * time.Second)
+	for fmt.Sprint(cc.current()) != fmt.Sprint(want) {
+		select {
+		case <-cc.updated:
+		case <-timeout:
+			t.Fatalf("addrs = %v, want %v", cc.current(), want)
+		}
+	}
+}
+
+func build(t *testing.T, reg *testutil.Registry, cc *fakeCC) resolver.Resolver {
+	t.Helper()
+	u, err := url.Parse(testutil.EtcdTarget(service))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := etcdresolver.NewBuilder(reg.Client()).Build(resolver.Target{URL: *u}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	return r
+}
+
+func put(reg *testutil.Registry, addr string) {
+	reg.Put(common.GenInstancePath(common.MySchemeEtcd, service, addr), addr)
+}
+
+func del(reg *testutil.Registry, addr string) {
+	reg.Delete(common.GenInstancePath(common.MySchemeEtcd, service, addr))
+}
+
+// watch中断期间实例发生变化并且etcd做了压缩，重新watch时收到ErrCompacted，全量同步之后地址正确
+func TestResolverCompaction(t *testing.T) {
+	reg := testutil.NewRegistry()
+	put(reg, "10.0.0.1:9090")
+	put(reg, "10.0.0.2:9090")
+	cc := newFakeCC()
+	build(t, reg, cc)
+	cc.wait(t, "10.0.0.1:9090", "10.0.0.2:9090")
+	// 确认watch已经建立
+	put(reg, "10.0.0.9:9090")
+	cc.wait(t, "10.0.0.1:9090", "10.0.0.2:9090", "10.0.0.9:9090")
+	del(reg, "10.0.0.9:9090")
+	cc.wait(t, "10.0.0.1:9090", "10.0.0.2:9090")
+
+	// 这期间的事件resolver收不到
+	reg.PauseWatches()
+	del(reg, "10.0.0.1:9090")
+	put(reg, "10.0.0.3:9090")
+	reg.Compact()
+	time.Sleep(50 * time.Millisecond)
+	if got := fmt.Sprint(cc.current()); got != "[10.0.0.1:9090 10.0.0.2:9090]" {
+		t.Fatalf("addrs = %s, events should be held while watch is paused", got)
+	}
+
+	reg.CancelWatches()
+	cc.wait(t, "10.0.0.2:9090", "10.0.0.3:9090")
+
+	// 全量同步之后从新的revision继续watch
+	put(reg, "10.0.0.4:9090")
+	cc.wait(t, "10.0.0.2:9090", "10.0.0.3:9090", "10.0.0.4:9090")
+	del(reg, "10.0.0.2:9090")
+	cc.wait(t, "10.0.0.3:9090", "10.0.0.4:9090")
+}
+
+// 并发的watch事件、ResolveNow和Close，配合-race检查锁的使用，最后实例全部下线时推送空列表并报错
+func TestResolverConcurrent(t *testing.T) {
+	reg := testutil.NewRegistry()
+	cc := newFakeCC()
+	r := build(t, reg, cc)
+
+	var writers, resolvers sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		writers.Add(1)
+		go func(i int) {
+			defer writers.Done()
+			addr := fmt.Sprintf("10.0.1.%d:9090", i)
+			for j := 0; j < 200; j++ {
+				put(reg, addr)
+				del(reg, addr)
+			}
+		}(i)
+		resolvers.Add(1)
+		go func() {
+			defer resolvers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					r.ResolveNow(resolver.ResolveNowOptions{})
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		writers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("writers blocked, resolver deadlocked")
+	}
+	cc.wait(t)
+	if cc.errs.Load() == 0 {
+		t.Fatal("empty address list not reported")
+	}
+
+	// 关闭的同时还有事件和ResolveNow
+	closed := make(chan struct{})
+	go func() {
+		r.Close()
+		close(closed)
+	}()
+	for i := 0; i < 100; i++ {
+		put(reg, "10.0.2.1:9090")
+		del(reg, "10.0.2.1:9090")
+	}
+	close(stop)
+	resolvers.Wait()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked")
+	}
+}
+
+// etcd中的__config__切换负载均衡策略：同一个连接从round_robin换成my_balancer，不用重建连接
+func TestServiceConfigSwitch(t *testing.T) {
+	reg := testutil.NewRegistry()
+	servers := make([]*testutil.Server, 3)
+	for i := range servers {
+		servers[i] = testutil.StartServer(t, testutil.WithRegistry(reg, service))
+	}
+	client := testutil.NewHelloClient(t, testutil.EtcdTarget(service), testutil.WithResolver(reg))
+	spread := func() []int64 {
+		before := make([]int64, len(servers))
+		for i, s := range servers {
+			before[i] = s.Hello.Calls()
+		}
+		for i := 0; i < 100; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_, err := client.SayHello(ctx, &pb.HelloRequest{Name: "config"})
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		got := make([]int64, len(servers))
+		for i, s := range servers {
+			got[i] = s.Hello.Calls() - before[i]
+		}
+		return got
+	}
+	// my_balancer只选前两个实例，按90%、10%分配
+	isMyBalancer := func(got []int64) bool {
+		var zero, major bool
+		for _, n := range got {
+			zero = zero || n == 0
+			major = major || n >= 70
+		}
+		return zero && major
+	}
+	waitFor := func(what string, cond func([]int64) bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			got := spread()
+			if cond(got) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: calls %v", what, got)
+			}
+		}
+	}
+
+	// 默认round_robin，每个实例都分到请求
+	waitFor("round_robin", func(got []int64) bool { return got[0] > 20 && got[1] > 20 && got[2] > 20 })
+
+	configPath := common.GenConfigPath(common.MySchemeEtcd, service)
+	reg.Put(configPath, common.GenServiceConfig("my_balancer"))
+	waitFor("my_balancer", isMyBalancer)
+
+	// 写错的配置被忽略，继续使用my_balancer
+	reg.Put(configPath, `{"loadBalancingPolicy":`)
+	time.Sleep(3 * etcdresolver.DebounceWindow)
+	if got := spread(); !isMyBalancer(got) {
+		t.Fatalf("invalid config applied, calls %v", got)
+	}
+
+	// 切回round_robin
+	reg.Put(configPath, common.GenServiceConfig("round_robin"))
+	waitFor("back to round_robin", func(got []int64) bool { return got[0] > 20 && got[1] > 20 && got[2] > 20 })
+}
@@ -0,0 +1,199 @@
+/**
+ * 服务注册到Etcd
+ * 整个进程只使用一个etcd客户端，注册流程：创建租约 -> 带着租约写入key -> 租约保活
+ * 保活的channel关闭（租约过期、etcd重启、网络中断等）时，重新创建租约并写入key，失败时按指数退避（带随机抖动）重试
+ * 注册状态的变化通过回调通知出去，比如打日志、上报监控、或者注册成功之后才把健康状态设置成SERVING
+ * ctx结束时撤销租约，key随之删除，客户端可以立即感知到实例下线
+ */
+package registrar
+
+import (
+	"context"
+	"errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"grpc-case/common"
+	"grpc-case/logging"
+	"time"
+)
+
+// 注册状态
+type State int
+
+const (
+	Registering State = iota // 正在注册（包括重试）
+	Registered               // 注册成功，正在保活
+	Lost                     // 保活中断，等待重新注册
+	Stopped                  // 已停止，key已删除
+)
+
+func (s State) String() string {
+	switch s {
+	case Registering:
+		return "registering"
+	case Registered:
+		return "registered"
+	case Lost:
+		return "lost"
+	case Stopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// 状态变化的回调，err是导致状态变化的错误（没有时为nil）
+type StateFunc func(state State, err error)
+
+type Option func(*Registrar)
+
+// 租约的ttl（秒），默认2秒
+func WithTTL(ttl int64) Option {
+	return func(r *Registrar) {
+		r.ttl = ttl
+	}
+}
+
+// 重试的退避参数，默认从1秒开始，最多30秒
+func WithBackoff(base, max time.Duration) Option {
+	return func(r *Registrar) {
+		r.backoffBase, r.backoffMax = base, max
+	}
+}
+
+// 每次创建租约、写入key的超时时间，默认5秒，etcd不可用时不会一直卡住
+func WithTimeout(timeout time.Duration) Option {
+	return func(r *Registrar) {
+		r.timeout = timeout
+	}
+}
+
+// 注册状态变化时的回调，在Run所在的goroutine中同步调用
+func WithStateFunc(fn StateFunc) Option {
+	return func(r *Registrar) {
+		r.onState = fn
+	}
+}
+
+func WithLogger(l *zap.Logger) Option {
+	return func(r *Registrar) {
+		r.log = l
+	}
+}
+
+type Registrar struct {
+	client *clientv3.Client
+	key    string
+	value  string
+
+	ttl         int64
+	timeout     time.Duration
+	backoffBase time.Duration
+	backoffMax  time.Duration
+	onState     StateFunc
+	log         *zap.Logger
+}
+
+// 创建Registrar，client由调用方创建和关闭
+func New(client *clientv3.Client, key, value string, opts ...Option) *Registrar {
+	r := &Registrar{
+		client:      client,
+		key:         key,
+		value:       value,
+		ttl:         2,
+		timeout:     5 * time.Second,
+		backoffBase: time.Second,
+		backoffMax:  30 * time.Second,
+		log:         logging.L().Named("registrar"),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.log = r.log.With(zap.String("key", key))
+	return r
+}
+
+// 注册并保活，阻塞直到ctx结束，结束前撤销租约
+func (r *Registrar) Run(ctx context.Context) error {
+	var retries int
+	for {
+		r.setState(Registering, nil)
+		leaseId, err := r.register(ctx)
+		if err == nil {
+			retries = 0
+			r.setState(Registered, nil)
+			err = r.keepAlive(ctx, leaseId)
+		}
+		if ctx.Err() != nil {
+			r.revoke(leaseId)
+			r.setState(Stopped, nil)
+			return nil
+		}
+		r.setState(Lost, err)
+
+		wait := common.Backoff(retries, r.backoffBase, r.backoffMax)
+		retries++
+		r.log.Warn("registration lost, retry", zap.Int("retries", retries), zap.Duration("backoff", wait), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			r.revoke(leaseId)
+			r.setState(Stopped, nil)
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// 创建租约并写入key
+func (r *Registrar) register(ctx context.Context) (clientv3.LeaseID, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	resp, err := r.client.Grant(ctx, r.ttl)
+	if err != nil {
+		return clientv3.NoLease, err
+	}
+	if _, err := r.client.Put(ctx, r.key, r.value, clientv3.WithLease(resp.ID)); err != nil {
+		r.revoke(resp.ID)
+		return clientv3.NoLease, err
+	}
+	r.log.Info("instance registered", zap.String("value", r.value), zap.Int64("lease", int64(resp.ID)), zap.Int64("ttl", resp.TTL))
+	return resp.ID, nil
+}
+
+// 租约保活，直到保活的channel关闭或者ctx结束
+func (r *Registrar) keepAlive(ctx context.Context, leaseId clientv3.LeaseID) error {
+	kaCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	respCh, err := r.client.KeepAlive(kaCtx, leaseId)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case resp, ok := <-respCh:
+			if !ok || resp == nil {
+				return errors.New("keepalive channel closed")
+			}
+			r.log.Debug("lease keepalive", zap.Int64("ttl", resp.TTL))
+		}
+	}
+}
+
+// 撤销租约，key随之删除；ctx已经结束，这里单独用一个短超时
+func (r *Registrar) revoke(leaseId clientv3.LeaseID) {
+	if leaseId == clientv3.NoLease {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := r.client.Revoke(ctx, leaseId); err != nil {
+		r.log.Warn("revoke lease failed", zap.Int64("lease", int64(leaseId)), zap.Error(err))
+	}
+}
+
+func (r *Registrar) setState(state State, err error) {
+	if r.onState != nil {
+		r.onState(state, err)
+	}
+}
@@ -0,0 +1,141 @@
+package registrar
+
+import (
+	"context"
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// 启动一个进程内的etcd，端口随机
+func startEtcd(t *testing.T) *clientv3.Client {
+	t.Helper()
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd not ready")
+	}
+
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{clientURL.Host}, DialTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+	return cli
+}
+
+func freeURL(t *testing.T) url.URL {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return url.URL{Scheme: "http", Host: lis.Addr().String()}
+}
+
+// 等待key出现（want为true）或者消失，返回key当前的租约
+func waitKey(t *testing.T, cli *clientv3.Client, key string, want bool) clientv3.LeaseID {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := cli.Get(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (len(resp.Kvs) > 0) == want {
+			if want {
+				return clientv3.LeaseID(resp.Kvs[0].Lease)
+			}
+			return clientv3.NoLease
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("key %s exists=%v timeout", key, want)
+	return clientv3.NoLease
+}
+
+func TestRegistrarEmbeddedEtcd(t *testing.T) {
+	cli := startEtcd(t)
+	const key, addr = "/etcd/svc/127.0.0.1:9090", "127.0.0.1:9090"
+
+	states := make(chan State, 16)
+	reg := New(cli, key, addr,
+		WithTTL(5),
+		WithBackoff(10*time.Millisecond, 50*time.Millisecond),
+		WithStateFunc(func(state State, err error) { states <- state }),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reg.Run(ctx)
+	}()
+
+	// 注册成功：key带着租约写入
+	lease := waitKey(t, cli, key, true)
+	if lease == clientv3.NoLease {
+		t.Fatal("key registered without lease")
+	}
+	resp, err := cli.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(resp.Kvs[0].Value); got != addr {
+		t.Fatalf("value = %q, want %q", got, addr)
+	}
+
+	// 租约丢失（相当于过期）：key被删除，之后用新的租约重新注册
+	if _, err := cli.Revoke(context.Background(), lease); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := cli.Get(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Kvs) > 0 && clientv3.LeaseID(resp.Kvs[0].Lease) != lease {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("not re-registered after lease revoked")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// 停止：撤销租约，key删除
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+	waitKey(t, cli, key, false)
+
+	close(states)
+	var seq []State
+	for s := range states {
+		seq = append(seq, s)
+	}
+	want := []State{Registering, Registered, Lost, Registering, Registered, Stopped}
+	if fmt.Sprint(seq) != fmt.Sprint(want) {
+		t.Fatalf("states = %v, want %v", seq, want)
+	}
+}
@@ -6,31 +6,32 @@ package main
 
 import (
 	"context"
-	"errors"
-	"flag"
-	"fmt"
 	etcdCLientv3 "go.etcd.io/etcd/client/v3"
-	"google.golang.org/grpc"
+	"go.uber.org/zap"
+	"grpc-case/bootstrap"
 	"grpc-case/common"
+	"grpc-case/config"
+	"grpc-case/discovery/etcd/server/registrar"
+	"grpc-case/loadreport"
+	"grpc-case/logging"
+	"grpc-case/metrics"
 	"grpc-case/pb"
+	"grpc-case/tracing"
 	"net"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 )
 
-var portStr *string = flag.String("p", common.BackEndPort0, "port")
-var nameStr *string = flag.String("n", common.MyServiceNameEtcd, "service name")
-var scheme *string = flag.String("s", common.MySchemeEtcd, "scheme")
-
 // 业务自己的Server，实现各个服务端方法
 type MyServer struct {
 	pb.UnimplementedHelloServiceServer //首先包装一个UnimplementedServer，使自身成为HelloServiceServer接口的实现
+	port                               string
 }
 
 // 实现业务代码
 func (m *MyServer) SayHello(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
-	fmt.Printf("Server[%v] Recv Request:%v\n", *portStr, req.Name)
+	logging.WithContext(ctx).Info("recv request", zap.String("port", m.port), zap.String("name", req.Name))
 	return &pb.HelloReply{
 		Message: "Hello " + req.Name,
 	}, nil
@@ -41,126 +42,103 @@ func (m *MyServer) SayHello(ctx context.Context, req *pb.HelloRequest) (*pb.Hell
 //服务端提前启动3个实例（这里为了说明原理，所以手动启）
 //go run server.go -p 9090
 //go run server.go -p 9091
-//go run server.go -p 9092
+//go run server.go -p 0                                   // 随机端口，注册的是实际监听的端口
+//go run server.go -p 9092 -advertise 10.0.0.1:19092      // 容器、NAT等场景，指定注册的地址
 
 // 服务启动起来
 func main() {
-	// 参数解析
-	flag.Parse()
+	// 加载配置
+	cfg := config.MustLoad(
+		config.Alias("p", "server.port"),
+		config.Alias("n", "server.name"),
+		config.Alias("s", "registry.scheme"),
+		config.Alias("advertise", "server.advertise"),
+		config.Alias("metrics", "server.metrics_addr"),
+	)
+
+	// 链路追踪，是否导出由环境变量OTEL_TRACES_EXPORTER决定，每个进程初始化一次
+	shutdownTracing, err := tracing.Init(context.Background(), "grpc-case")
+	if err != nil {
+		panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// 暴露监控指标
+	if cfg.Server.MetricsAddr != "" {
+		if _, err := metrics.Serve(cfg.Server.MetricsAddr); err != nil {
+			panic(err)
+		}
+	}
 
-	// 创建监听端口
-	addr := common.BackEnd0
-	if strings.Contains(common.BackEnd1, *portStr) {
-		addr = common.BackEnd1
-	} else if strings.Contains(common.BackEnd2, *portStr) {
-		addr = common.BackEnd2
+	// 创建监听端口，可以是任意地址，包括 :0
+	listener, err := net.Listen("tcp", cfg.Server.ListenAddr())
+	if err != nil {
+		panic(err)
 	}
-	listener, err := net.Listen("tcp", addr)
+	// 注册到Etcd中的地址
+	addr, err := bootstrap.AdvertiseAddr(cfg.Server.Advertise, listener.Addr())
 	if err != nil {
 		panic(err)
 	}
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
 
-	// 创建grpc服务
-	grpcServer := grpc.NewServer()
+	// 创建grpc服务，同时通过ORCA上报负载（CPU、正在处理的请求数），客户端使用orca_weighted策略时按负载分配流量
+	ctx, cancel := context.WithCancel(context.Background())
+	lr := loadreport.New()
+	grpcServer, err := bootstrap.NewServerFromConfig(cfg, lr.ServerOptions()...)
+	if err != nil {
+		panic(err)
+	}
+	if err := lr.Register(grpcServer.Server); err != nil {
+		panic(err)
+	}
+	go lr.Run(ctx, time.Second)
 
 	// 在grpc服务中，注册业务自己的服务（也就是将自己的Server对象与grpc服务绑定）
-	pb.RegisterHelloServiceServer(grpcServer, &MyServer{})
+	pb.RegisterHelloServiceServer(grpcServer, &MyServer{port: port})
+
+	// 收到退出信号时先撤销注册（等待key删除完成），客户端摘掉这个实例之后再停止服务
+	regDone := make(chan struct{})
+	grpcServer.BeforeDrain = func() {
+		cancel()
+		<-regDone
+	}
 
 	// 启动grpc服务
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
-		fmt.Printf("Server Start! Name:%v, Port:%v\n", *nameStr, *portStr)
+		logging.L().Info("server start", zap.String("service", cfg.Server.Name), zap.String("listen", listener.Addr().String()), zap.String("advertise", addr))
 		defer wg.Done()
-		err = grpcServer.Serve(listener)
-		if err != nil {
+		if err := grpcServer.Serve(listener); err != nil {
 			panic(err)
 		}
 	}()
-	ctx, cancel := context.WithCancel(context.Background())
-
-	// 服务注册到Etcd
-	go func() {
-		// 无限循环等待，注册
-		for {
-			err = registerToEtcd(ctx, *nameStr, addr)
-			if err != nil && err.Error() == "over" {
-				fmt.Println("正常退出")
-				return
-			} else {
-				fmt.Println("遇到问题：" + err.Error() + ". 准备重新注册！！！！！！！")
-			}
-		}
-	}()
-
-	// 等待
-	wg.Wait()
-
-	// 关闭etcd相关操作
-	cancel()
-}
-
-// 注册到Etcd
-func registerToEtcd(ctx context.Context, service, addr string) error {
-	// 创建客户端
+	// 创建etcd客户端，整个进程只用这一个
 	etcdCli, err := etcdCLientv3.New(etcdCLientv3.Config{
-		Endpoints:   []string{common.EtcdAddr},
-		DialTimeout: common.EtcdTimeout * time.Second,
+		Endpoints:   cfg.Registry.Endpoints,
+		DialTimeout: cfg.Registry.DialTimeout,
 	})
 	if err != nil {
-		return fmt.Errorf("etcdCLientv3.New Error:%v", err)
+		panic(err)
 	}
 	defer etcdCli.Close()
 
-	// 创建租约
-	resp, err := etcdCli.Grant(ctx, 2) // ttl = 2，表示存活时间是2秒，如果不续约就消失
-	if err != nil {
-		return fmt.Errorf("Grant Err:%v", err)
-	}
-	fmt.Println("申请租约：Grant-------", resp.ID)
-
-	// 注册
-	key := common.GenInstancePath(*scheme, service, addr)
-	_, err = etcdCli.Put(ctx, key, addr, etcdCLientv3.WithLease(resp.ID))
-	if err != nil {
-		return fmt.Errorf("Etcd Put Err:%v", err)
-	}
-	fmt.Println("注册：Put-------", key, "=>", addr)
-
-	// 租约保活
-	respCh, err := etcdCli.KeepAlive(ctx, resp.ID)
-	if err != nil {
-		return fmt.Errorf("Etcd Keep Alive Err:%v", err)
-	}
-
-	// 异步监听保活的结果
-	i := 0
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Println("实例退出")
-			return errors.New("over")
-		case v, ok := <-respCh:
-			if v == nil {
-				// 如果失败了，要重新注册并续租
-				fmt.Println("续租租约失效", ok)
-				return errors.New("continue")
-			} else {
-				if i%100 == 0 {
-					fmt.Println(time.Now().Format(time.DateTime),
-						"续租租约成功"+fmt.Sprintf("(%v)", i), v, ok)
-				}
-				i++
-			}
-		}
-	}
+	// 服务注册到Etcd，租约丢失时自动重新注册
+	key := common.GenInstancePath(cfg.Registry.Scheme, cfg.Server.Name, addr)
+	reg := registrar.New(etcdCli, key, addr,
+		registrar.WithTTL(cfg.Registry.LeaseTTL), // 默认ttl = 2，表示存活时间是2秒，如果不续约就消失
+		registrar.WithStateFunc(func(state registrar.State, err error) {
+			logging.L().Info("registration state", zap.String("state", state.String()), zap.Error(err))
+		}),
+	)
+	go func() {
+		defer close(regDone)
+		reg.Run(ctx)
+	}()
 
-	// 测试租约回收
-	//go func() {
-	//	time.Sleep(10 * time.Second)
-	//	_, err = etcdCli.Revoke(ctx, resp.ID) // 撤销租约
-	//	if err != nil {
-	//		panic(err)
-	//	}
-	//}()
+	// 等待
+	wg.Wait()
+	cancel()
+	<-regDone
 }
@@ -0,0 +1,342 @@
+/**
+ * 多集群故障转移的resolver，把多个服务发现来源按优先级组合起来
+ * 比如每个机房一套etcd，优先访问本机房的实例，本机房没有实例时切到其他机房，本机房恢复之后再切回来
+ *
+ * 地址格式：
+ *  failover:///name                         来源通过Define("name", ...)预先定义
+ *  failover:///name?target=A&target=B       来源直接写在地址里，按出现的顺序，越靠前优先级越高
+ * 来源可以是任意已注册的resolver（etcd://10.0.0.1:2379/svc、file:endpoints.json?service=svc、srv:///...），
+ * 也可以是固定的地址：static:///127.0.0.1:9090,127.0.0.1:9091
+ *
+ * 切换规则：
+ *  1. 始终使用优先级最高的、有实例的来源
+ *  2. 当前来源变空之后，等待FailoverDelay（默认0）仍然为空，才切到下一个有实例的来源，避免短暂的抖动
+ *  3. 更高优先级的来源恢复之后，需要持续有实例RecoverHoldDown（默认30秒），才会切回去，防止来回切换
+ *  4. 所有来源都没有实例时，推送空的地址列表并ReportError，请求直接失败，不会继续发往已经下线的实例
+ */
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"grpc-case/logging"
+	"grpc-case/metrics"
+	"grpc-case/tracing"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const Scheme = "failover"
+
+// 固定地址的来源
+const StaticScheme = "static"
+
+var (
+	// 当前来源变空之后，等待多久再切到下一个来源
+	FailoverDelay time.Duration
+	// 高优先级的来源恢复之后，持续有实例多久才切回去
+	RecoverHoldDown = 30 * time.Second
+)
+
+var (
+	groupsMu sync.RWMutex
+	groups   = map[string][]string{}
+)
+
+// 预先定义一组来源，按优先级从高到低，需要在创建连接之前调用
+func Define(name string, targets ...string) {
+	groupsMu.Lock()
+	defer groupsMu.Unlock()
+	groups[name] = targets
+}
+
+func init() {
+	resolver.Register(&failoverBuilder{})
+}
+
+// 可以通过logging.SetNamed("resolver.failover", ...)注入logger
+func log() *zap.Logger {
+	return logging.Named("resolver.failover")
+}
+
+type failoverBuilder struct{}
+
+func (*failoverBuilder) Scheme() string {
+	return Scheme
+}
+
+func (*failoverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	name := target.Endpoint()
+	targets := target.URL.Query()["target"]
+	if len(targets) == 0 {
+		groupsMu.RLock()
+		targets = groups[name]
+		groupsMu.RUnlock()
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("failover resolver: no sources for %q, use Define or ?target=", name)
+	}
+	log().Info("build", zap.String("target", target.URL.String()), zap.Strings("sources", targets))
+
+	r := &failoverResolver{
+		target:  target,
+		cc:      cc,
+		name:    name,
+		active:  -1,
+		sources: make([]*source, len(targets)),
+		pushCh:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	for i, t := range targets {
+		r.sources[i] = &source{target: t}
+	}
+	go r.pushLoop()
+
+	// 子resolver在Build中就可能同步地回调UpdateState，所以先把sources准备好再逐个创建
+	for i, t := range targets {
+		child, err := r.buildChild(i, t, opts)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failover resolver: source %q: %v", t, err)
+		}
+		r.mu.Lock()
+		r.sources[i].resolver = child
+		r.mu.Unlock()
+	}
+	return r, nil
+}
+
+// 一个来源
+type source struct {
+	target   string
+	resolver resolver.Resolver // static来源为nil
+	state    resolver.State
+	since    time.Time // 最近一次从空变成非空（或者从非空变成空）的时间
+}
+
+func (s *source) empty() bool {
+	return len(s.state.Addresses) == 0
+}
+
+type failoverResolver struct {
+	target resolver.Target
+	cc     resolver.ClientConn
+	name   string
+
+	mu      sync.Mutex
+	sources []*source
+	active  int // 当前使用的来源，-1表示还没有
+	timer   *time.Timer
+	pending *resolver.State // 待推送的状态，只保留最新的
+	pendErr error
+	closed  bool
+
+	pushCh chan struct{}
+	done   chan struct{}
+}
+
+// 创建子resolver，static来源直接设置地址
+func (r *failoverResolver) buildChild(idx int, t string, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	u, err := url.Parse(t)
+	if err != nil || u.Scheme == "" || (u.Opaque != "" && resolver.Get(u.Scheme) == nil) {
+		// 没有scheme的当作固定地址，比如 127.0.0.1:9090、localhost:9090
+		u = &url.URL{Scheme: StaticScheme, Path: "/" + t}
+	}
+	if u.Scheme == StaticScheme {
+		var addrs []resolver.Address
+		for _, a := range strings.Split(strings.TrimPrefix(u.Path, "/"), ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				addrs = append(addrs, resolver.Address{Addr: a})
+			}
+		}
+		r.onUpdate(idx, resolver.State{Addresses: addrs})
+		return nil, nil
+	}
+	builder := resolver.Get(u.Scheme)
+	if builder == nil {
+		return nil, fmt.Errorf("unknown scheme %q", u.Scheme)
+	}
+	return builder.Build(resolver.Target{URL: *u}, &childConn{parent: r, idx: idx}, opts)
+}
+
+// 子resolver的地址变化
+func (r *failoverResolver) onUpdate(idx int, state resolver.State) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	src := r.sources[idx]
+	if src.empty() != (len(state.Addresses) == 0) {
+		src.since = time.Now()
+	}
+	src.state = state
+	log().Debug("source updated", zap.String("source", src.target), zap.Int("addrs", len(state.Addresses)))
+	r.evaluateLocked()
+}
+
+// 根据各个来源的状态选择使用哪一个，需要持有锁
+func (r *failoverResolver) evaluateLocked() {
+	if r.closed {
+		return
+	}
+	now := time.Now()
+	next := -1
+	hold := false          // 当前来源刚变空，还在FailoverDelay之内，先不推送
+	var wait time.Duration // 多久之后需要重新评估
+	activeUsable := r.active >= 0 && !r.sources[r.active].empty()
+	for i, src := range r.sources {
+		if src.empty() {
+			// 当前来源刚变空，FailoverDelay之内继续使用
+			if i == r.active && FailoverDelay > 0 {
+				if left := FailoverDelay - now.Sub(src.since); left > 0 {
+					next, hold = i, true
+					wait = minWait(wait, left)
+					break
+				}
+			}
+			continue
+		}
+		// 当前来源可用时，比它优先级高的来源需要持续有实例RecoverHoldDown才切回去
+		if activeUsable && i < r.active {
+			if left := RecoverHoldDown - now.Sub(src.since); left > 0 {
+				wait = minWait(wait, left)
+				continue
+			}
+		}
+		next = i
+		break
+	}
+
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	if wait > 0 {
+		r.timer = time.AfterFunc(wait, func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.evaluateLocked()
+		})
+	}
+
+	if next != r.active {
+		from := "none"
+		if r.active >= 0 {
+			from = r.sources[r.active].target
+		}
+		to := "none"
+		if next >= 0 {
+			to = r.sources[next].target
+		}
+		log().Info("switch source", zap.String("name", r.name), zap.String("from", from), zap.String("to", to))
+		tracing.RecordEvent("resolver.Failover", "source switched",
+			attribute.String("target", r.target.URL.String()), attribute.String("from", from), attribute.String("to", to))
+		r.active = next
+	}
+
+	if hold {
+		return
+	}
+	if next < 0 {
+		// 所有来源都没有实例：推送空的地址列表，不再把请求发给已经下线的实例，同时报错让请求尽快失败
+		r.pending, r.pendErr = &resolver.State{}, errors.New("failover resolver: no instances in any source")
+	} else {
+		state := r.sources[next].state
+		r.pending, r.pendErr = &state, nil
+	}
+	select {
+	case r.pushCh <- struct{}{}:
+	default:
+	}
+}
+
+// 在单独的goroutine中把状态推给grpc，避免持有锁时回调grpc，同时保证推送的顺序
+func (r *failoverResolver) pushLoop() {
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.pushCh:
+		}
+		r.mu.Lock()
+		state, err := r.pending, r.pendErr
+		r.pending, r.pendErr = nil, nil
+		r.mu.Unlock()
+		if state != nil {
+			metrics.ResolverAddresses.WithLabelValues(Scheme, r.name).Set(float64(len(state.Addresses)))
+			r.cc.UpdateState(*state)
+		}
+		if err != nil {
+			r.cc.ReportError(err)
+		}
+	}
+}
+
+func (r *failoverResolver) ResolveNow(o resolver.ResolveNowOptions) {
+	r.mu.Lock()
+	children := make([]resolver.Resolver, 0, len(r.sources))
+	for _, src := range r.sources {
+		if src.resolver != nil {
+			children = append(children, src.resolver)
+		}
+	}
+	r.mu.Unlock()
+	for _, c := range children {
+		c.ResolveNow(o)
+	}
+}
+
+func (r *failoverResolver) Close() {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	sources := r.sources
+	r.mu.Unlock()
+	for _, src := range sources {
+		if src.resolver != nil {
+			src.resolver.Close()
+		}
+	}
+	close(r.done)
+}
+
+func minWait(cur, d time.Duration) time.Duration {
+	if cur == 0 || d < cur {
+		return d
+	}
+	return cur
+}
+
+// 子resolver使用的ClientConn，把更新转给failoverResolver
+type childConn struct {
+	parent *failoverResolver
+	idx    int
+}
+
+func (c *childConn) UpdateState(state resolver.State) error {
+	c.parent.onUpdate(c.idx, state)
+	return nil
+}
+
+// 子resolver出错时保留它之前的地址，只记录日志
+func (c *childConn) ReportError(err error) {
+	log().Warn("source error", zap.String("source", c.parent.sources[c.idx].target), zap.Error(err))
+}
+
+func (c *childConn) NewAddress(addresses []resolver.Address) {
+	c.UpdateState(resolver.State{Addresses: addresses})
+}
+
+func (c *childConn) ParseServiceConfig(serviceConfigJSON string) *serviceconfig.ParseResult {
+	return c.parent.cc.ParseServiceConfig(serviceConfigJSON)
+}
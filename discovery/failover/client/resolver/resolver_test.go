@@ -0,0 +1,248 @@
+package resolver_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	failover "grpc-case/discovery/failover/client/resolver"
+	fileresolver "grpc-case/discovery/file/client/resolver"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const service = "hello_failover"
+
+// 记录failover resolver推送的地址，代替grpc的ClientConn
+type fakeCC struct {
+	resolver.ClientConn
+	mu      sync.Mutex
+	pushed  bool
+	addrs   []string
+	updated chan struct{}
+	errs    atomic.Int64 // ReportError的次数
+}
+
+func (cc *fakeCC) UpdateState(s resolver.State) error {
+	addrs := make([]string, len(s.Addresses))
+	for i, a := range s.Addresses {
+		addrs[i] = a.Addr
+	}
+	sort.Strings(addrs)
+	cc.mu.Lock()
+	cc.pushed, cc.addrs = true, addrs
+	cc.mu.Unlock()
+	select {
+	case cc.updated <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (cc *fakeCC) ReportError(error) {
+	cc.errs.Add(1)
+}
+
+func (cc *fakeCC) ParseServiceConfig(string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{}
+}
+
+// 最近一次推送的地址，还没有推送过时返回false
+func (cc *fakeCC) current() (string, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return fmt.Sprint(cc.addrs), cc.pushed
+}
+
+// 等待最近一次推送的地址等于want
+func (cc *fakeCC) wait(t *testing.T, want []string) {
+	t.Helper()
+	sort.Strings(want)
+	timeout := time.After(5 * time.Second)
+	for {
+		if got, ok := cc.current(); ok && got == fmt.Sprint(want) {
+			return
+		}
+		select {
+		case <-cc.updated:
+		case <-timeout:
+			got, _ := cc.current()
+			t.Fatalf("addrs = %v, want %v", got, want)
+		}
+	}
+}
+
+// d时间内推送的地址一直是want
+func (cc *fakeCC) stay(t *testing.T, d time.Duration, want []string) {
+	t.Helper()
+	sort.Strings(want)
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		if got, _ := cc.current(); got != fmt.Sprint(want) {
+			t.Fatalf("addrs = %v, want %v to be kept for %v", got, want, d)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// 写入来源的实例文件，修改时间往后推，保证每次写入都能被file resolver检查到
+func writeSource(t *testing.T, path string, addrs []string) {
+	t.Helper()
+	content, err := json.Marshal(map[string][]string{service: append([]string{}, addrs...)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	next := time.Now().Add(time.Second)
+	if info, err := os.Stat(path); err == nil && !info.ModTime().Before(next) {
+		next = info.ModTime().Add(time.Second)
+	}
+	if err := os.Chtimes(path, next, next); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 修改一个来源的实例，然后检查推送的地址
+type step struct {
+	source int
+	addrs  []string
+	stay   time.Duration // 修改之后这段时间内仍然是上一步的地址
+	want   []string      // 最终推送的地址
+	errs   bool          // 是否ReportError
+}
+
+func TestFailover(t *testing.T) {
+	oldPoll, oldDelay, oldHold := fileresolver.PollInterval, failover.FailoverDelay, failover.RecoverHoldDown
+	fileresolver.PollInterval = 10 * time.Millisecond
+	t.Cleanup(func() {
+		fileresolver.PollInterval, failover.FailoverDelay, failover.RecoverHoldDown = oldPoll, oldDelay, oldHold
+	})
+
+	const a, a2, b, c1 = "10.0.0.1:9090", "10.0.0.2:9090", "10.0.1.1:9090", "10.0.2.1:9090"
+	cases := []struct {
+		name    string
+		delay   time.Duration // FailoverDelay
+		hold    time.Duration // RecoverHoldDown
+		sources [][]string    // 各个来源初始的实例，按优先级从高到低
+		want    []string
+		steps   []step
+	}{
+		{
+			name:    "highest priority non-empty",
+			sources: [][]string{nil, {b}, {c1}},
+			want:    []string{b},
+			steps: []step{
+				// 当前来源的实例变化直接推送
+				{source: 1, addrs: []string{b, a2}, want: []string{b, a2}},
+				{source: 1, addrs: nil, want: []string{c1}},
+			},
+		},
+		{
+			name:    "failover delay",
+			delay:   300 * time.Millisecond,
+			sources: [][]string{{a}, {b}},
+			want:    []string{a},
+			steps: []step{
+				// FailoverDelay之内继续使用当前来源，之后切到b
+				{source: 0, addrs: nil, stay: 200 * time.Millisecond, want: []string{b}},
+			},
+		},
+		{
+			name:    "failover delay recovered",
+			delay:   300 * time.Millisecond,
+			hold:    time.Minute,
+			sources: [][]string{{a}, {b}},
+			want:    []string{a},
+			steps: []step{
+				// FailoverDelay之内恢复，不切换
+				{source: 0, addrs: nil, stay: 100 * time.Millisecond, want: []string{a}},
+				{source: 0, addrs: []string{a2}, want: []string{a2}},
+			},
+		},
+		{
+			name:    "recover hold down",
+			hold:    300 * time.Millisecond,
+			sources: [][]string{nil, {b}},
+			want:    []string{b},
+			steps: []step{
+				// a恢复之后持续有实例RecoverHoldDown才切回去
+				{source: 0, addrs: []string{a}, stay: 200 * time.Millisecond, want: []string{a}},
+			},
+		},
+		{
+			name:    "flap",
+			hold:    300 * time.Millisecond,
+			sources: [][]string{nil, {b}},
+			want:    []string{b},
+			steps: []step{
+				{source: 0, addrs: []string{a}, stay: 150 * time.Millisecond, want: []string{b}},
+				// a又变空，hold-down重新计时
+				{source: 0, addrs: nil, stay: 50 * time.Millisecond, want: []string{b}},
+				{source: 0, addrs: []string{a}, stay: 250 * time.Millisecond, want: []string{a}},
+			},
+		},
+		{
+			name:    "all empty",
+			sources: [][]string{{a}, nil},
+			want:    []string{a},
+			steps: []step{
+				// 所有来源都没有实例，推送空列表并报错
+				{source: 0, addrs: nil, want: nil, errs: true},
+				{source: 1, addrs: []string{b}, want: []string{b}},
+			},
+		},
+	}
+	for i, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			failover.FailoverDelay, failover.RecoverHoldDown = c.delay, c.hold
+			dir := t.TempDir()
+			paths := make([]string, len(c.sources))
+			targets := make([]string, len(c.sources))
+			for j, addrs := range c.sources {
+				paths[j] = filepath.Join(dir, fmt.Sprintf("source%d.json", j))
+				writeSource(t, paths[j], addrs)
+				targets[j] = fileresolver.Scheme + "://" + paths[j] + "?service=" + service
+			}
+			name := fmt.Sprintf("failover_%d", i)
+			failover.Define(name, targets...)
+			u, err := url.Parse(failover.Scheme + ":///" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc := &fakeCC{updated: make(chan struct{}, 1)}
+			r, err := resolver.Get(failover.Scheme).Build(resolver.Target{URL: *u}, cc, resolver.BuildOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(r.Close)
+			cc.wait(t, c.want)
+
+			prev := c.want
+			for _, s := range c.steps {
+				errs := cc.errs.Load()
+				writeSource(t, paths[s.source], s.addrs)
+				if s.stay > 0 {
+					cc.stay(t, s.stay, prev)
+				}
+				cc.wait(t, s.want)
+				// ReportError在UpdateState之后调用
+				deadline := time.Now().Add(time.Second)
+				for s.errs && cc.errs.Load() == errs && time.Now().Before(deadline) {
+					time.Sleep(5 * time.Millisecond)
+				}
+				if got := cc.errs.Load() > errs; got != s.errs {
+					t.Fatalf("ReportError called %v, want %v", got, s.errs)
+				}
+				prev = s.want
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+/**
+ * 基于本地文件的服务发现客户端
+ * 不依赖Etcd，适合本地开发和测试，修改endpoints.json之后地址列表会自动更新
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/health" // 开启客户端健康检查
+	"grpc-case/common"
+	"grpc-case/config"
+	_ "grpc-case/discovery/file/client/resolver" // 注册file:///
+	"grpc-case/logging"
+	"grpc-case/metrics"
+	"grpc-case/middleware"
+	"grpc-case/pb"
+	"grpc-case/tracing"
+	"time"
+)
+
+// Note:
+// 服务端启动2个实例（go run ./discovery/basic/server -p 9090，-p 9091）
+// 在仓库根目录下启动 go run ./discovery/file/client
+// 然后修改 discovery/file/endpoints.json，增删实例，观察请求的分布
+func main() {
+	// 加载配置，这个例子默认访问 file:discovery/file/endpoints.json?service=myservicename_file
+	cfg := config.MustLoad(
+		config.ForClient(),
+		config.Alias("target", "client.target"),
+		config.Alias("metrics", "client.metrics_addr"),
+		config.Alias("lb", "balancer.policy"),
+		config.WithDefaults(func(c *config.Config) {
+			c.Client.Target = "file:discovery/file/endpoints.json?service=myservicename_file"
+		}),
+	)
+
+	// 链路追踪，是否导出由环境变量OTEL_TRACES_EXPORTER决定
+	shutdown, err := tracing.Init(context.Background(), "grpc-case-client")
+	if err != nil {
+		panic(err)
+	}
+	defer shutdown(context.Background())
+
+	// 暴露监控指标
+	if cfg.Client.MetricsAddr != "" {
+		if _, err := metrics.Serve(cfg.Client.MetricsAddr); err != nil {
+			panic(err)
+		}
+	}
+
+	creds, err := cfg.TLS.ClientCredentials()
+	if err != nil {
+		panic(err)
+	}
+	conn, err := grpc.NewClient(cfg.Client.Target,
+		grpc.WithTransportCredentials(creds),
+		tracing.DialOption(), // 链路追踪
+		grpc.WithChainUnaryInterceptor( // 请求ID、日志、监控、超时拦截器
+			middleware.RequestIdUnaryClientInterceptor(),
+			logging.UnaryClientInterceptor(logging.L()),
+			metrics.UnaryClientInterceptor(),
+			middleware.DeadlineUnaryClientInterceptor(cfg.Client.DeadlineMargin, cfg.Client.Timeout),
+		),
+		grpc.WithDefaultServiceConfig(common.GenServiceConfig(cfg.Balancer.Policy)),
+	)
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	// 基于连接创建客户端
+	client := pb.NewHelloServiceClient(conn)
+
+	// 执行RPC调用
+	for i := 0; i < 300; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Client.Timeout)
+		rsp, err := client.SayHello(ctx, &pb.HelloRequest{
+			Name: "msg_" + fmt.Sprintf("%v", i),
+		})
+		cancel()
+		if err != nil {
+			panic(err)
+		}
+
+		// 返回结果
+		fmt.Println("Recv: ", rsp.Message)
+		time.Sleep(1 * time.Second)
+	}
+}
@@ -0,0 +1,199 @@
+/**
+ * 基于本地文件的服务发现
+ * 地址格式：
+ *  file:///abs/path/endpoints.json?service=myservicename_file   （绝对路径）
+ *  file:discovery/file/endpoints.json?service=myservicename_file （相对于运行目录）
+ *  文件中只有一个服务时，service参数可以省略
+ *
+ * 文件格式（JSON），服务名 => 实例列表，实例可以直接写地址，也可以带上权重和属性：
+ *  {
+ *    "myservicename_file": [
+ *      "127.0.0.1:9090",
+ *      {"addr": "127.0.0.1:9091", "weight": 3, "attributes": {"zone": "b"}}
+ *    ]
+ *  }
+ *
+ * 不依赖etcd，定时检查文件是否有变化（修改时间、大小），有变化就重新读取并通过cc.UpdateState推给grpc
+ * 文件读取或者解析失败时，保留上一次正确的地址列表
+ */
+package resolver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/resolver"
+	"grpc-case/discovery/attributes"
+	"grpc-case/logging"
+	"grpc-case/metrics"
+	"grpc-case/tracing"
+	"os"
+	"time"
+)
+
+const Scheme = "file"
+
+// 检查文件变化的间隔，需要在创建连接之前设置
+var PollInterval = time.Second
+
+func init() {
+	resolver.Register(&fileBuilder{})
+}
+
+// 可以通过logging.SetNamed("resolver.file", ...)注入logger
+func log() *zap.Logger {
+	return logging.Named("resolver.file")
+}
+
+type fileBuilder struct{}
+
+func (*fileBuilder) Scheme() string {
+	return Scheme
+}
+
+func (*fileBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	// file:///abs/path 的路径在Path中，file:rel/path 的路径在Opaque中
+	path := target.URL.Path
+	if path == "" {
+		path = target.URL.Opaque
+	}
+	if path == "" {
+		return nil, fmt.Errorf("file resolver: missing path in target %q", target.URL.String())
+	}
+	log().Info("build", zap.String("target", target.URL.String()), zap.String("path", path))
+
+	r := &fileResolver{
+		target:  target,
+		cc:      cc,
+		path:    path,
+		service: target.URL.Query().Get("service"),
+		trigger: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	// 第一次读取失败直接返回错误，免得连接一直等不到地址
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
+
+type fileResolver struct {
+	target  resolver.Target
+	cc      resolver.ClientConn
+	path    string
+	service string
+
+	// 上一次读取时文件的状态，没有变化就不再读取
+	modTime time.Time
+	size    int64
+	content []byte
+
+	trigger chan struct{}
+	done    chan struct{}
+}
+
+// 立即检查一次文件，由watch goroutine执行，这里只是发个信号
+func (r *fileResolver) ResolveNow(o resolver.ResolveNowOptions) {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (r *fileResolver) Close() {
+	close(r.done)
+}
+
+// 定时检查文件的变化
+func (r *fileResolver) watch() {
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		case <-r.trigger:
+		}
+		if err := r.reload(); err != nil {
+			log().Warn("reload failed, keep last addresses", zap.String("path", r.path), zap.Error(err))
+		}
+	}
+}
+
+// 文件有变化时重新读取，并更新地址
+func (r *fileResolver) reload() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		r.cc.ReportError(err)
+		return err
+	}
+	if info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return nil
+	}
+	content, err := os.ReadFile(r.path)
+	if err != nil {
+		r.cc.ReportError(err)
+		return err
+	}
+	r.modTime, r.size = info.ModTime(), info.Size()
+	// 只是touch了一下，内容没变
+	if bytes.Equal(content, r.content) {
+		return nil
+	}
+
+	endpoints, err := r.parse(content)
+	if err != nil {
+		r.cc.ReportError(err)
+		return err
+	}
+	r.content = content
+	r.update(endpoints)
+	return nil
+}
+
+// 解析文件，取出目标服务的实例列表
+func (r *fileResolver) parse(content []byte) ([]attributes.Endpoint, error) {
+	var services map[string][]attributes.Endpoint
+	if err := json.Unmarshal(content, &services); err != nil {
+		return nil, fmt.Errorf("parse %v: %v", r.path, err)
+	}
+	service := r.service
+	if service == "" {
+		if len(services) != 1 {
+			return nil, fmt.Errorf("%v has %d services, specify one with ?service=", r.path, len(services))
+		}
+		for name := range services {
+			service = name
+		}
+	}
+	endpoints, ok := services[service]
+	if !ok {
+		return nil, fmt.Errorf("service %q not found in %v", service, r.path)
+	}
+	for _, e := range endpoints {
+		if e.Addr == "" {
+			return nil, errors.New("endpoint without addr in " + r.path)
+		}
+	}
+	return endpoints, nil
+}
+
+func (r *fileResolver) update(endpoints []attributes.Endpoint) {
+	addrStrs := make([]string, len(endpoints))
+	instanceList := make([]resolver.Address, len(endpoints))
+	for i, e := range endpoints {
+		addrStrs[i] = e.Addr
+		instanceList[i] = e.Address()
+	}
+	log().Info("addresses updated", zap.String("path", r.path), zap.Strings("addrs", addrStrs))
+	metrics.ResolverAddresses.WithLabelValues(Scheme, r.target.URL.String()).Set(float64(len(instanceList)))
+	tracing.RecordEvent("resolver.UpdateState", "addresses updated",
+		attribute.String("target", r.target.URL.String()), attribute.StringSlice("addrs", addrStrs))
+
+	r.cc.UpdateState(resolver.State{Addresses: instanceList})
+}
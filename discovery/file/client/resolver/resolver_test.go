@@ -0,0 +1,86 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"grpc-case/pb"
+	"grpc-case/testutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 写入实例文件，修改时间往后推，保证每次写入都能被检查到
+func writeEndpoints(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	next := time.Now().Add(time.Second)
+	if info, err := os.Stat(path); err == nil && !info.ModTime().Before(next) {
+		next = info.ModTime().Add(time.Second)
+	}
+	if err := os.Chtimes(path, next, next); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 修改文件之后，请求发到新的实例，写错的文件不影响当前的地址；不需要etcd等外部依赖
+func TestFileResolver(t *testing.T) {
+	old := PollInterval
+	PollInterval = 20 * time.Millisecond
+	t.Cleanup(func() { PollInterval = old })
+
+	a := testutil.StartServer(t)
+	b := testutil.StartServer(t)
+	path := filepath.Join(t.TempDir(), "endpoints.json")
+	writeEndpoints(t, path, fmt.Sprintf(`{"hello_file": [%q], "other": ["127.0.0.1:1"]}`, a.Addr))
+	client := testutil.NewHelloClient(t, Scheme+"://"+path+"?service=hello_file")
+
+	// 发出n个请求，返回每个实例处理的请求数
+	spread := func(n int) (int64, int64) {
+		beforeA, beforeB := a.Hello.Calls(), b.Hello.Calls()
+		for i := 0; i < n; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_, err := client.SayHello(ctx, &pb.HelloRequest{Name: "file"})
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return a.Hello.Calls() - beforeA, b.Hello.Calls() - beforeB
+	}
+	waitFor := func(what string, cond func(a, b int64) bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			ga, gb := spread(10)
+			if cond(ga, gb) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: calls a=%d b=%d", what, ga, gb)
+			}
+		}
+	}
+
+	if ga, gb := spread(10); ga != 10 || gb != 0 {
+		t.Fatalf("calls a=%d b=%d, want all on a", ga, gb)
+	}
+
+	// 换成b（带权重的写法）
+	writeEndpoints(t, path, fmt.Sprintf(`{"hello_file": [{"addr": %q, "weight": 2}], "other": ["127.0.0.1:1"]}`, b.Addr))
+	waitFor("switch to b", func(a, b int64) bool { return a == 0 && b == 10 })
+
+	// 写错的文件被忽略，继续使用b
+	writeEndpoints(t, path, `{"hello_file": [`)
+	time.Sleep(5 * PollInterval)
+	if ga, gb := spread(10); ga != 0 || gb != 10 {
+		t.Fatalf("invalid file applied, calls a=%d b=%d", ga, gb)
+	}
+
+	// 两个实例
+	writeEndpoints(t, path, fmt.Sprintf(`{"hello_file": [%q, %q]}`, a.Addr, b.Addr))
+	waitFor("both", func(a, b int64) bool { return a > 0 && b > 0 })
+}
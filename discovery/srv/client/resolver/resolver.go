@@ -0,0 +1,346 @@
+/**
+ * 基于DNS SRV记录的服务发现
+ * 地址格式：srv:///_grpc._tcp.service.domain
+ *
+ * SRV记录中的weight和priority写到地址的属性中（见discovery/attributes），orca_weighted等按权重分配的balancer会使用weight
+ * 刷新时机：
+ *  1. 记录的TTL到期（取所有应答记录中最小的TTL）
+ *  2. grpc调用ResolveNow（比如连接断开），两次查询之间至少间隔MinResolveInterval
+ *  3. 查询失败时按指数退避重试，重试期间保留上一次成功的地址列表
+ *
+ * Note: 标准库的net.LookupSRV拿不到TTL，所以默认的查询函数（NetLookup）自己用dnsmessage构造SRV查询，
+ *       发给/etc/resolv.conf中的第一个nameserver，测试中可以通过net.Resolver.Dial指向本地的DNS桩服务器
+ */
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"golang.org/x/net/dns/dnsmessage"
+	"google.golang.org/grpc/resolver"
+	"grpc-case/common"
+	"grpc-case/discovery/attributes"
+	"grpc-case/logging"
+	"grpc-case/metrics"
+	"grpc-case/tracing"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const Scheme = "srv"
+
+var (
+	// 查询函数没有返回TTL（或者TTL为0）时使用的刷新间隔
+	DefaultTTL = 30 * time.Second
+	// 两次查询之间的最小间隔，避免ResolveNow过于频繁
+	MinResolveInterval = time.Second
+	// 每次查询的超时时间
+	LookupTimeout = 5 * time.Second
+	// 查询失败时的退避参数
+	BackoffBase = time.Second
+	BackoffMax  = 2 * time.Minute
+)
+
+// 一条SRV记录
+type Record struct {
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+}
+
+// 查询函数：返回name对应的SRV记录，以及记录的TTL（0表示使用DefaultTTL）
+type LookupFunc func(ctx context.Context, name string) ([]Record, time.Duration, error)
+
+var lookup atomic.Pointer[LookupFunc]
+
+// 替换查询函数，需要在创建连接之前调用
+func SetLookup(fn LookupFunc) {
+	lookup.Store(&fn)
+}
+
+// 使用指定的net.Resolver的Dial发送SRV查询，没有设置Dial时直接连接nameserver
+// 返回应答中所有SRV记录，以及其中最小的TTL；UDP应答被截断时改用TCP重新查询
+func NetLookup(r *net.Resolver) LookupFunc {
+	return func(ctx context.Context, name string) ([]Record, time.Duration, error) {
+		if !strings.HasSuffix(name, ".") {
+			name += "."
+		}
+		qname, err := dnsmessage.NewName(name)
+		if err != nil {
+			return nil, 0, err
+		}
+		id := uint16(rand.Uint32())
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+		b.EnableCompression()
+		if err := b.StartQuestions(); err != nil {
+			return nil, 0, err
+		}
+		if err := b.Question(dnsmessage.Question{Name: qname, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET}); err != nil {
+			return nil, 0, err
+		}
+		query, err := b.Finish()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		rsp, err := exchange(ctx, r, "udp", query)
+		if err != nil {
+			return nil, 0, err
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(rsp)
+		if err == nil && h.Truncated {
+			if rsp, err = exchange(ctx, r, "tcp", query); err != nil {
+				return nil, 0, err
+			}
+			h, err = p.Start(rsp)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if h.ID != id || !h.Response {
+			return nil, 0, fmt.Errorf("lookup %s: mismatched response", name)
+		}
+		if h.RCode != dnsmessage.RCodeSuccess {
+			return nil, 0, fmt.Errorf("lookup %s: %s", name, h.RCode)
+		}
+		if err := p.SkipAllQuestions(); err != nil {
+			return nil, 0, err
+		}
+
+		var records []Record
+		var ttl uint32
+		for {
+			rh, err := p.AnswerHeader()
+			if err == dnsmessage.ErrSectionDone {
+				break
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			if rh.Type != dnsmessage.TypeSRV {
+				if err := p.SkipAnswer(); err != nil {
+					return nil, 0, err
+				}
+				continue
+			}
+			srv, err := p.SRVResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			records = append(records, Record{Target: srv.Target.String(), Port: srv.Port, Priority: srv.Priority, Weight: srv.Weight})
+			if len(records) == 1 || rh.TTL < ttl {
+				ttl = rh.TTL
+			}
+		}
+		if len(records) == 0 {
+			return nil, 0, fmt.Errorf("lookup %s: no SRV records", name)
+		}
+		return records, time.Duration(ttl) * time.Second, nil
+	}
+}
+
+// 发送一次查询并读取应答，TCP需要加上两个字节的长度前缀
+func exchange(ctx context.Context, r *net.Resolver, network string, query []byte) ([]byte, error) {
+	dial := r.Dial
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	conn, err := dial(ctx, network, nameserver())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// /etc/resolv.conf中的第一个nameserver，读不到时使用本机
+func nameserver() string {
+	if data, err := os.ReadFile("/etc/resolv.conf"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+func getLookup() LookupFunc {
+	if fn := lookup.Load(); fn != nil {
+		return *fn
+	}
+	return NetLookup(net.DefaultResolver)
+}
+
+func init() {
+	resolver.Register(&srvBuilder{})
+}
+
+// 可以通过logging.SetNamed("resolver.srv", ...)注入logger
+func log() *zap.Logger {
+	return logging.Named("resolver.srv")
+}
+
+type srvBuilder struct{}
+
+func (*srvBuilder) Scheme() string {
+	return Scheme
+}
+
+func (*srvBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	log().Info("build", zap.String("target", target.URL.String()), zap.String("name", target.Endpoint()))
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &srvResolver{
+		target:  target,
+		cc:      cc,
+		name:    target.Endpoint(),
+		lookup:  getLookup(),
+		trigger: make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	r.wg.Add(1)
+	go r.watch()
+	return r, nil
+}
+
+type srvResolver struct {
+	target resolver.Target
+	cc     resolver.ClientConn
+	name   string
+	lookup LookupFunc
+
+	trigger chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// 通知watch goroutine立即查询一次
+func (r *srvResolver) ResolveNow(o resolver.ResolveNowOptions) {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (r *srvResolver) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// 查询并更新地址，成功后等待TTL，失败后按退避时间重试，期间收到ResolveNow会提前查询
+func (r *srvResolver) watch() {
+	defer r.wg.Done()
+	var retries int
+	for {
+		last := time.Now()
+		var wait time.Duration
+		ttl, err := r.resolve()
+		if err != nil {
+			wait = common.Backoff(retries, BackoffBase, BackoffMax)
+			retries++
+			log().Warn("lookup failed", zap.String("name", r.name), zap.Int("retries", retries), zap.Duration("backoff", wait), zap.Error(err))
+			r.cc.ReportError(err)
+		} else {
+			retries = 0
+			wait = ttl
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-r.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-r.trigger:
+			timer.Stop()
+			// 离上次查询太近的话，等够最小间隔再查
+			if d := MinResolveInterval - time.Since(last); d > 0 {
+				select {
+				case <-r.ctx.Done():
+					return
+				case <-time.After(d):
+				}
+			}
+		}
+	}
+}
+
+// 查询一次并推给grpc，返回下一次刷新的间隔
+func (r *srvResolver) resolve() (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(r.ctx, LookupTimeout)
+	defer cancel()
+	records, ttl, err := r.lookup(ctx, r.name)
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if ttl < MinResolveInterval {
+		ttl = MinResolveInterval
+	}
+
+	addrStrs := make([]string, len(records))
+	instanceList := make([]resolver.Address, len(records))
+	for i, rec := range records {
+		host := strings.TrimSuffix(rec.Target, ".")
+		addr := resolver.Address{Addr: net.JoinHostPort(host, strconv.Itoa(int(rec.Port)))}
+		addr = attributes.SetWeight(addr, uint32(rec.Weight))
+		addr = attributes.SetPriority(addr, uint32(rec.Priority))
+		addrStrs[i] = addr.Addr
+		instanceList[i] = addr
+	}
+	log().Debug("lookup done", zap.String("name", r.name), zap.Strings("addrs", addrStrs), zap.Duration("ttl", ttl))
+	metrics.ResolverAddresses.WithLabelValues(Scheme, r.name).Set(float64(len(instanceList)))
+	tracing.RecordEvent("resolver.UpdateState", "addresses updated",
+		attribute.String("target", r.target.URL.String()), attribute.StringSlice("addrs", addrStrs))
+
+	r.cc.UpdateState(resolver.State{Addresses: instanceList})
+	return ttl, nil
+}
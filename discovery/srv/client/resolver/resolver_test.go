@@ -0,0 +1,284 @@
+package resolver
+
+import (
+	"context"
+	"golang.org/x/net/dns/dnsmessage"
+	"google.golang.org/grpc/resolver"
+	"grpc-case/discovery/attributes"
+	"grpc-case/pb"
+	"grpc-case/testutil"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const srvName = "_grpc._tcp.hello.test."
+
+// 本地的DNS桩服务器，只回答srvName的SRV查询，记录可以在测试中修改
+type stubDNS struct {
+	conn net.PacketConn
+
+	mu      sync.Mutex
+	records []Record
+	ttl     uint32 // 第i条记录的TTL为ttl+i，最小的TTL是ttl
+}
+
+func startStubDNS(t *testing.T) *stubDNS {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubDNS{conn: conn, ttl: 1}
+	t.Cleanup(func() { conn.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *stubDNS) set(records ...Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = records
+}
+
+func (s *stubDNS) setTTL(ttl uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ttl = ttl
+}
+
+// 查询函数，net.Resolver的所有查询都发给桩服务器
+func (s *stubDNS) lookup() LookupFunc {
+	return NetLookup(&net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", s.conn.LocalAddr().String())
+		},
+	})
+}
+
+func (s *stubDNS) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(buf[:n])
+		if err != nil {
+			continue
+		}
+		q, err := p.Question()
+		if err != nil {
+			continue
+		}
+		rsp, err := s.answer(h, q)
+		if err != nil {
+			continue
+		}
+		s.conn.WriteTo(rsp, addr)
+	}
+}
+
+func (s *stubDNS) answer(h dnsmessage.Header, q dnsmessage.Question) ([]byte, error) {
+	s.mu.Lock()
+	records, ttl := s.records, s.ttl
+	s.mu.Unlock()
+
+	rh := dnsmessage.Header{ID: h.ID, Response: true, Authoritative: true}
+	if q.Type != dnsmessage.TypeSRV || q.Name.String() != srvName {
+		rh.RCode = dnsmessage.RCodeNameError
+	}
+	b := dnsmessage.NewBuilder(nil, rh)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if rh.RCode == dnsmessage.RCodeSuccess {
+		for i, rec := range records {
+			err := b.SRVResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: ttl + uint32(i)},
+				dnsmessage.SRVResource{Priority: rec.Priority, Weight: rec.Weight, Port: rec.Port, Target: dnsmessage.MustNewName(rec.Target)})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b.Finish()
+}
+
+func TestNetLookup(t *testing.T) {
+	dns := startStubDNS(t)
+	cases := []struct {
+		name    string
+		records []Record
+		ttl     uint32
+	}{
+		{"one", []Record{{Target: "a.hello.test.", Port: 9090, Priority: 1, Weight: 10}}, 30},
+		{"two", []Record{{Target: "a.hello.test.", Port: 9090, Priority: 1, Weight: 10}, {Target: "b.hello.test.", Port: 9091, Priority: 2, Weight: 5}}, 7},
+		{"zero ttl", []Record{{Target: "a.hello.test.", Port: 9090, Weight: 1}}, 0},
+		{"empty", nil, 30},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dns.set(c.records...)
+			dns.setTTL(c.ttl)
+			got, ttl, err := dns.lookup()(context.Background(), srvName)
+			if c.records == nil {
+				// 没有记录时返回错误，resolver会保留上一次的地址并退避重试
+				if err == nil {
+					t.Fatalf("want error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 多条记录时取最小的TTL
+			if ttl != time.Duration(c.ttl)*time.Second {
+				t.Fatalf("ttl = %v, want %ds", ttl, c.ttl)
+			}
+			if len(got) != len(c.records) {
+				t.Fatalf("got %v, want %v", got, c.records)
+			}
+			want := map[Record]bool{}
+			for _, r := range c.records {
+				want[r] = true
+			}
+			for _, r := range got {
+				if !want[r] {
+					t.Fatalf("unexpected record %v", r)
+				}
+			}
+		})
+	}
+}
+
+// 记录推给grpc的状态
+type fakeCC struct {
+	resolver.ClientConn
+	state resolver.State
+}
+
+func (cc *fakeCC) UpdateState(s resolver.State) error {
+	cc.state = s
+	return nil
+}
+
+// 下一次刷新的间隔跟随DNS应答中最小的TTL（TTL为0时使用DefaultTTL，不小于MinResolveInterval），priority和weight写到地址属性中
+func TestResolveInterval(t *testing.T) {
+	oldTTL, oldInterval := DefaultTTL, MinResolveInterval
+	DefaultTTL, MinResolveInterval = time.Hour, 2*time.Second
+	t.Cleanup(func() { DefaultTTL, MinResolveInterval = oldTTL, oldInterval })
+
+	dns := startStubDNS(t)
+	dns.set(Record{Target: "a.hello.test.", Port: 9090, Priority: 1, Weight: 10}, Record{Target: "b.hello.test.", Port: 9091, Priority: 2, Weight: 5})
+	cases := []struct {
+		name string
+		ttl  uint32
+		want time.Duration
+	}{
+		{"ttl", 5, 5 * time.Second},
+		{"min interval", 1, MinResolveInterval},
+		{"default", 0, DefaultTTL},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dns.setTTL(c.ttl)
+			cc := &fakeCC{}
+			r := &srvResolver{cc: cc, name: srvName, lookup: dns.lookup(), ctx: context.Background()}
+			interval, err := r.resolve()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if interval != c.want {
+				t.Fatalf("refresh interval = %v, want %v", interval, c.want)
+			}
+
+			want := map[string][2]uint32{"a.hello.test:9090": {1, 10}, "b.hello.test:9091": {2, 5}}
+			if len(cc.state.Addresses) != len(want) {
+				t.Fatalf("addresses = %v", cc.state.Addresses)
+			}
+			for _, addr := range cc.state.Addresses {
+				w, ok := want[addr.Addr]
+				if !ok {
+					t.Fatalf("unexpected address %v", addr.Addr)
+				}
+				if p, _ := addr.Attributes.Value(attributes.PriorityKey{}).(uint32); p != w[0] {
+					t.Fatalf("%s priority = %d, want %d", addr.Addr, p, w[0])
+				}
+				if got := attributes.Weight(addr); got != w[1] {
+					t.Fatalf("%s weight = %d, want %d", addr.Addr, got, w[1])
+				}
+			}
+		})
+	}
+}
+
+// 通过srv:///访问两个服务端，DNS记录变化之后在TTL内切换到新的地址
+func TestResolverRefresh(t *testing.T) {
+	oldTTL, oldInterval := DefaultTTL, MinResolveInterval
+	DefaultTTL, MinResolveInterval = 100*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() { DefaultTTL, MinResolveInterval = oldTTL, oldInterval })
+
+	a := testutil.StartServer(t, testutil.WithTCP())
+	b := testutil.StartServer(t, testutil.WithTCP())
+	dns := startStubDNS(t)
+	// TTL为0，按DefaultTTL刷新
+	dns.setTTL(0)
+	SetLookup(dns.lookup())
+	t.Cleanup(func() { lookup.Store(nil) })
+	dns.set(record(t, a.Addr), record(t, b.Addr))
+
+	client := testutil.NewHelloClient(t, Scheme+":///"+srvName)
+	call := func(n int) {
+		for i := 0; i < n; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_, err := client.SayHello(ctx, &pb.HelloRequest{Name: "srv"})
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// 等两个实例都就绪
+	deadline := time.Now().Add(5 * time.Second)
+	for a.Hello.Calls() == 0 || b.Hello.Calls() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("calls a=%d b=%d, want both", a.Hello.Calls(), b.Hello.Calls())
+		}
+		call(1)
+	}
+
+	// 记录中去掉a，刷新之后所有请求都发给b
+	dns.set(record(t, b.Addr))
+	time.Sleep(3 * DefaultTTL)
+	before := a.Hello.Calls()
+	call(20)
+	if got := a.Hello.Calls() - before; got != 0 {
+		t.Fatalf("%d calls went to removed instance", got)
+	}
+}
+
+func record(t *testing.T, addr string) Record {
+	t.Helper()
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SRV记录的target是域名，不写IP
+	return Record{Target: "localhost.", Port: uint16(p), Weight: 1}
+}
@@ -0,0 +1,187 @@
+/**
+ * 富错误模型（google.rpc.Status + details）
+ * 直接errors.New返回给客户端的话，客户端只能拿到codes.Unknown和一段字符串，没法用程序判断
+ * 这里提供：
+ *  1. 服务端：构造带ErrorInfo、RetryInfo、QuotaFailure、BadRequest详情的status
+ *  2. 客户端：从错误中把这些详情取出来，根据Reason做判断
+ *
+ * Note: 包名和标准库的errors相同，使用时一般起个别名，比如 grpcerrors "grpc-case/errors"
+ */
+package errors
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"grpc-case/logging"
+	"strings"
+	"time"
+)
+
+// ErrorInfo中的Domain
+const Domain = "grpc-case"
+
+// 本项目用到的错误原因（ErrorInfo.Reason），大写下划线风格，客户端根据它做判断
+const (
+	ReasonMissingMetadata  = "MISSING_METADATA"
+	ReasonInvalidToken     = "INVALID_TOKEN"
+	ReasonInvalidArgument  = "INVALID_ARGUMENT"
+	ReasonDeadlineTooShort = "DEADLINE_TOO_SHORT"
+	ReasonOverloaded       = "OVERLOADED"
+	ReasonInternal         = "INTERNAL"
+)
+
+// 错误的构造器
+//
+//	return grpcerrors.New(codes.Unauthenticated, grpcerrors.ReasonInvalidToken, "invalid appId or appKey").
+//		Meta("appid", appId).Err()
+type Builder struct {
+	code    codes.Code
+	msg     string
+	info    *errdetails.ErrorInfo
+	details []protoadapt.MessageV1
+}
+
+// 生成一个带ErrorInfo的错误，reason为空时不带ErrorInfo
+// 带详情时message和详情一起序列化成google.rpc.Status，所以message中非法的UTF-8也要替换掉
+func New(code codes.Code, reason, format string, args ...any) *Builder {
+	b := &Builder{code: code, msg: strings.ToValidUTF8(fmt.Sprintf(format, args...), "?")}
+	if reason != "" {
+		b.info = &errdetails.ErrorInfo{Reason: reason, Domain: Domain}
+	}
+	return b
+}
+
+// 在ErrorInfo中附加一些元信息
+func (b *Builder) Meta(key, value string) *Builder {
+	if b.info == nil {
+		b.info = &errdetails.ErrorInfo{Domain: Domain}
+	}
+	if b.info.Metadata == nil {
+		b.info.Metadata = map[string]string{}
+	}
+	b.info.Metadata[key] = value
+	return b
+}
+
+// 建议客户端多久之后重试
+func (b *Builder) RetryAfter(d time.Duration) *Builder {
+	b.details = append(b.details, &errdetails.RetryInfo{RetryDelay: durationpb.New(d)})
+	return b
+}
+
+// 配额不足
+func (b *Builder) QuotaViolation(subject, description string) *Builder {
+	q := b.quotaFailure()
+	q.Violations = append(q.Violations, &errdetails.QuotaFailure_Violation{Subject: subject, Description: description})
+	return b
+}
+
+// 参数错误
+func (b *Builder) FieldViolation(field, description string) *Builder {
+	return b.FieldViolations(&errdetails.BadRequest_FieldViolation{Field: field, Description: description})
+}
+
+// 一次添加多个参数错误
+func (b *Builder) FieldViolations(violations ...*errdetails.BadRequest_FieldViolation) *Builder {
+	br := b.badRequest()
+	br.FieldViolations = append(br.FieldViolations, violations...)
+	return b
+}
+
+// 附加其他任意的详情，比如DebugInfo
+func (b *Builder) Detail(details ...protoadapt.MessageV1) *Builder {
+	b.details = append(b.details, details...)
+	return b
+}
+
+// 生成status
+func (b *Builder) Status() *status.Status {
+	st := status.New(b.code, b.msg)
+	details := b.details
+	if b.info != nil {
+		details = append([]protoadapt.MessageV1{b.info}, details...)
+	}
+	if len(details) == 0 {
+		return st
+	}
+	for _, d := range details {
+		sanitize(protoadapt.MessageV2Of(d).ProtoReflect())
+	}
+	ds, err := st.WithDetails(details...)
+	if err == nil {
+		return ds
+	}
+	// 一般不会走到这里，万一某个详情序列化失败，逐个添加，只丢掉有问题的那个，不能把ErrorInfo也一起丢掉
+	logging.L().Warn("add error details failed", zap.String("msg", b.msg), zap.Error(err))
+	for _, d := range details {
+		if ds, err := st.WithDetails(d); err == nil {
+			st = ds
+		}
+	}
+	return st
+}
+
+// proto的string字段必须是合法的UTF-8，否则序列化失败，这里把非法的字节替换掉
+// 比如按字节截断的中文、panic信息中的二进制数据
+func sanitize(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Kind() == protoreflect.StringKind {
+				mp := v.Map()
+				mp.Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+					mp.Set(k, protoreflect.ValueOfString(strings.ToValidUTF8(mv.String(), "?")))
+					return true
+				})
+			}
+		case fd.IsList():
+			l := v.List()
+			for i := 0; i < l.Len(); i++ {
+				switch fd.Kind() {
+				case protoreflect.StringKind:
+					l.Set(i, protoreflect.ValueOfString(strings.ToValidUTF8(l.Get(i).String(), "?")))
+				case protoreflect.MessageKind:
+					sanitize(l.Get(i).Message())
+				}
+			}
+		case fd.Kind() == protoreflect.StringKind:
+			m.Set(fd, protoreflect.ValueOfString(strings.ToValidUTF8(v.String(), "?")))
+		case fd.Kind() == protoreflect.MessageKind:
+			sanitize(v.Message())
+		}
+		return true
+	})
+}
+
+// 生成error，直接作为handler的返回值
+func (b *Builder) Err() error {
+	return b.Status().Err()
+}
+
+func (b *Builder) quotaFailure() *errdetails.QuotaFailure {
+	for _, d := range b.details {
+		if q, ok := d.(*errdetails.QuotaFailure); ok {
+			return q
+		}
+	}
+	q := &errdetails.QuotaFailure{}
+	b.details = append(b.details, q)
+	return q
+}
+
+func (b *Builder) badRequest() *errdetails.BadRequest {
+	for _, d := range b.details {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			return br
+		}
+	}
+	br := &errdetails.BadRequest{}
+	b.details = append(b.details, br)
+	return br
+}
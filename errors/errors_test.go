@@ -0,0 +1,152 @@
+package errors
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"testing"
+	"time"
+)
+
+// 模拟经过网络：status序列化成grpc-status-details-bin，客户端再解析出来
+func roundTrip(t *testing.T, err error) error {
+	t.Helper()
+	st, ok := status.FromError(err)
+	if !ok {
+		t.Fatalf("%v is not a status error", err)
+	}
+	b, err := proto.Marshal(st.Proto())
+	if err != nil {
+		t.Fatalf("marshal status: %v", err)
+	}
+	var p spb.Status
+	if err := proto.Unmarshal(b, &p); err != nil {
+		t.Fatalf("unmarshal status: %v", err)
+	}
+	return status.FromProto(&p).Err()
+}
+
+func TestRoundTrip(t *testing.T) {
+	const invalid = "bad\xff"
+	cases := []struct {
+		name  string
+		err   error
+		code  codes.Code
+		check func(t *testing.T, err error)
+	}{
+		{
+			name: "error info",
+			err:  New(codes.Unauthenticated, ReasonInvalidToken, "invalid appId or appKey").Meta("appid", "123").Err(),
+			code: codes.Unauthenticated,
+			check: func(t *testing.T, err error) {
+				info := Info(err)
+				if !Is(err, ReasonInvalidToken) || info.GetDomain() != Domain || info.GetMetadata()["appid"] != "123" {
+					t.Fatalf("error info %v", info)
+				}
+			},
+		},
+		{
+			name: "retry info",
+			err:  New(codes.ResourceExhausted, ReasonOverloaded, "overloaded").RetryAfter(1500 * time.Millisecond).Err(),
+			code: codes.ResourceExhausted,
+			check: func(t *testing.T, err error) {
+				if d, ok := RetryDelay(err); !ok || d != 1500*time.Millisecond {
+					t.Fatalf("retry delay %v %v", d, ok)
+				}
+				if !Is(err, ReasonOverloaded) {
+					t.Fatalf("reason %q", Reason(err))
+				}
+			},
+		},
+		{
+			name: "quota failure",
+			err:  New(codes.ResourceExhausted, ReasonOverloaded, "quota").QuotaViolation("tenant:123", "qps").QuotaViolation("tenant:456", "concurrency").Err(),
+			code: codes.ResourceExhausted,
+			check: func(t *testing.T, err error) {
+				v := QuotaViolations(err)
+				if len(v) != 2 || v[0].GetSubject() != "tenant:123" || v[1].GetDescription() != "concurrency" {
+					t.Fatalf("quota violations %v", v)
+				}
+			},
+		},
+		{
+			name: "bad request",
+			err:  New(codes.InvalidArgument, ReasonInvalidArgument, "invalid").FieldViolation("name", "required").FieldViolation("age", "too small").Err(),
+			code: codes.InvalidArgument,
+			check: func(t *testing.T, err error) {
+				v := FieldViolations(err)
+				if len(v) != 2 || v[0].GetField() != "name" || v[1].GetDescription() != "too small" {
+					t.Fatalf("field violations %v", v)
+				}
+			},
+		},
+		{
+			name: "no reason",
+			err:  New(codes.NotFound, "", "not found").Err(),
+			code: codes.NotFound,
+			check: func(t *testing.T, err error) {
+				if Info(err) != nil || Reason(err) != "" {
+					t.Fatalf("unexpected error info %v", Info(err))
+				}
+			},
+		},
+		{
+			// 非法的UTF-8替换掉，详情不会因为序列化失败而丢失
+			name: "invalid utf8",
+			err: New(codes.Internal, ReasonInternal, "panic: %s", invalid).Meta("panic", invalid).
+				QuotaViolation(invalid, invalid).FieldViolation("name", invalid).
+				Detail(&errdetails.DebugInfo{Detail: invalid, StackEntries: []string{invalid}}).Err(),
+			code: codes.Internal,
+			check: func(t *testing.T, err error) {
+				const want = "bad?"
+				if msg := status.Convert(err).Message(); msg != "panic: "+want {
+					t.Fatalf("message %q", msg)
+				}
+				if !Is(err, ReasonInternal) || Info(err).GetMetadata()["panic"] != want {
+					t.Fatalf("error info %v", Info(err))
+				}
+				if v := QuotaViolations(err); len(v) != 1 || v[0].GetSubject() != want || v[0].GetDescription() != want {
+					t.Fatalf("quota violations %v", v)
+				}
+				if v := FieldViolations(err); len(v) != 1 || v[0].GetDescription() != want {
+					t.Fatalf("field violations %v", v)
+				}
+				var debug *errdetails.DebugInfo
+				for _, d := range status.Convert(err).Details() {
+					if di, ok := d.(*errdetails.DebugInfo); ok {
+						debug = di
+					}
+				}
+				if debug.GetDetail() != want || len(debug.GetStackEntries()) != 1 || debug.GetStackEntries()[0] != want {
+					t.Fatalf("debug info %v", debug)
+				}
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := roundTrip(t, c.err)
+			if code := status.Code(err); code != c.code {
+				t.Fatalf("code = %v, want %v", code, c.code)
+			}
+			c.check(t, err)
+		})
+	}
+}
+
+// 不是grpc的错误，或者没有错误时，取详情的函数都返回零值
+func TestExtractNonStatus(t *testing.T) {
+	for _, err := range []error{nil, New(codes.OK, "", "").Err()} {
+		if Info(err) != nil || Reason(err) != "" || Is(err, ReasonInternal) {
+			t.Fatalf("%v: unexpected error info", err)
+		}
+		if _, ok := RetryDelay(err); ok {
+			t.Fatalf("%v: unexpected retry delay", err)
+		}
+		if QuotaViolations(err) != nil || FieldViolations(err) != nil {
+			t.Fatalf("%v: unexpected violations", err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package errors
+
+/*
+ * 客户端使用：从grpc错误中取出各种详情
+ */
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// 取出ErrorInfo，没有时返回nil
+func Info(err error) *errdetails.ErrorInfo {
+	for _, d := range details(err) {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info
+		}
+	}
+	return nil
+}
+
+// 取出错误原因，没有ErrorInfo时返回空字符串
+func Reason(err error) string {
+	return Info(err).GetReason()
+}
+
+// 判断错误原因
+func Is(err error, reason string) bool {
+	return err != nil && Reason(err) == reason
+}
+
+// 取出服务端建议的重试间隔
+func RetryDelay(err error) (time.Duration, bool) {
+	for _, d := range details(err) {
+		if ri, ok := d.(*errdetails.RetryInfo); ok && ri.GetRetryDelay() != nil {
+			return ri.GetRetryDelay().AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+// 取出配额相关的错误
+func QuotaViolations(err error) []*errdetails.QuotaFailure_Violation {
+	var violations []*errdetails.QuotaFailure_Violation
+	for _, d := range details(err) {
+		if q, ok := d.(*errdetails.QuotaFailure); ok {
+			violations = append(violations, q.GetViolations()...)
+		}
+	}
+	return violations
+}
+
+// 取出参数相关的错误
+func FieldViolations(err error) []*errdetails.BadRequest_FieldViolation {
+	var violations []*errdetails.BadRequest_FieldViolation
+	for _, d := range details(err) {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			violations = append(violations, br.GetFieldViolations()...)
+		}
+	}
+	return violations
+}
+
+func details(err error) []any {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return nil
+	}
+	return st.Details()
+}
@@ -0,0 +1,114 @@
+/**
+ * HTTP/JSON网关
+ * 基于grpc-gateway，把pb/hello.proto里面通过google.api.http注解声明的方法，暴露成REST/JSON接口
+ *  POST /v1/hello        body: {"name":"foo"}
+ *  GET  /v1/hello/{name}
+ *
+ * 1. HTTP请求头会转成grpc的metadata透传给后端，所以token认证（appid/appkey）照样生效
+ *    返回的header和trailer转成Grpc-Metadata-和Grpc-Trailer-开头的HTTP头，二进制的metadata（-bin结尾）不返回
+ * 2. grpc的错误码会映射成HTTP状态码（比如InvalidArgument=>400，Unauthenticated=>401，Unavailable=>503）
+ * 3. 既可以单独部署（见gateway/server），也可以和grpc服务共用一个端口（见ServeMux）
+ *    开启TLS时，cmux看不到加密之后的content-type，没法在一个端口上分流，HTTP单独监听一个端口（见ServeTLS）
+ */
+package gateway
+
+import (
+	"context"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/soheilhy/cmux"
+	"google.golang.org/grpc"
+	"grpc-case/bootstrap"
+	"grpc-case/config"
+	"grpc-case/pb"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// 这些是HTTP协议本身的头，不应该透传到grpc的metadata中
+var hopHeaders = map[string]bool{
+	"connection":          true,
+	"keep-alive":          true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"te":                  true,
+	"trailer":             true,
+	"transfer-encoding":   true,
+	"upgrade":             true,
+	"content-length":      true,
+}
+
+// 决定哪些HTTP头要转成metadata，以及转成什么key
+// 标准的HTTP头（比如Authorization、User-Agent）仍按grpc-gateway的默认规则处理（加grpcgateway-前缀）
+// 其他自定义头全部透传，key转成小写（metadata的key必须小写，见token/server里的说明）
+func HeaderMatcher(key string) (string, bool) {
+	if k, ok := runtime.DefaultHeaderMatcher(key); ok {
+		return k, true
+	}
+	k := strings.ToLower(key)
+	if hopHeaders[k] {
+		return "", false
+	}
+	return k, true
+}
+
+// 返回的metadata转成HTTP头，二进制的metadata（比如ORCA的endpoint-load-metrics-bin）不是合法的HTTP头，丢掉
+func outgoingMatcher(prefix string) runtime.HeaderMatcherFunc {
+	return func(key string) (string, bool) {
+		if strings.HasSuffix(key, "-bin") {
+			return "", false
+		}
+		return prefix + key, true
+	}
+}
+
+// 基于一个已经建立好的grpc连接，生成HTTP网关的Handler
+// conn的target可以是任意地址，包括etcd:///xxx 和 myscheme1:///xxx
+func NewHandler(ctx context.Context, conn *grpc.ClientConn) (http.Handler, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(HeaderMatcher),
+		runtime.WithOutgoingHeaderMatcher(outgoingMatcher(runtime.MetadataHeaderPrefix)),
+		runtime.WithOutgoingTrailerMatcher(outgoingMatcher(runtime.MetadataTrailerPrefix)),
+		// 默认的错误处理就是 runtime.HTTPStatusFromCode 把grpc错误码映射成HTTP状态码，
+		// 并把status（code、message、details）序列化成JSON返回
+		runtime.WithErrorHandler(runtime.DefaultHTTPErrorHandler),
+	)
+	// 以后在proto里新增的方法，只要加了HTTP注解，重新生成代码后在这里注册一下就行
+	if err := pb.RegisterHelloServiceHandler(ctx, mux, conn); err != nil {
+		return nil, err
+	}
+	return mux, nil
+}
+
+// 在同一个端口上同时提供grpc和HTTP服务（cmux的方式：根据请求的content-type分流）
+// 阻塞直到grpc服务停止，停止之后HTTP服务也一起关闭
+func ServeMux(lis net.Listener, grpcServer *bootstrap.Server, h http.Handler) error {
+	m := cmux.New(lis)
+	// grpc的客户端会等待服务端的SETTINGS帧，所以要用MatchWithWriters
+	grpcL := m.MatchWithWriters(cmux.HTTP2MatchHeaderFieldSendSettings("content-type", "application/grpc"))
+	httpL := m.Match(cmux.Any())
+
+	httpServer := &http.Server{Handler: h}
+	go httpServer.Serve(httpL)
+	go m.Serve()
+
+	err := grpcServer.Serve(grpcL)
+	httpServer.Close()
+	m.Close()
+	return err
+}
+
+// 开启TLS时：grpc服务使用lis，HTTP网关监听httpAddr，使用同一套证书
+// 阻塞直到grpc服务停止，停止之后HTTP服务也一起关闭
+func ServeTLS(lis net.Listener, grpcServer *bootstrap.Server, httpAddr string, tlsCfg *config.TLSConfig, h http.Handler) error {
+	httpL, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		return err
+	}
+	httpServer := &http.Server{Handler: h}
+	go httpServer.ServeTLS(httpL, tlsCfg.CertFile, tlsCfg.KeyFile)
+
+	err = grpcServer.Serve(lis)
+	httpServer.Close()
+	return err
+}
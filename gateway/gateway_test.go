@@ -0,0 +1,152 @@
+package gateway_test
+
+import (
+	"context"
+	"encoding/json"
+	"grpc-case/common"
+	"grpc-case/config"
+	"grpc-case/gateway"
+	"grpc-case/pb"
+	"grpc-case/testutil"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+var token = config.AuthConfig{AppId: "123", AppKey: "abc"}
+
+// 通过HTTP网关访问，返回状态码、JSON响应和trailer（请求头带上TE: trailers时网关才会返回grpc的trailer）
+func request(t *testing.T, method, url, body string, header map[string]string) (int, map[string]any, http.Header) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	b, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]any
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatalf("invalid json %q: %v", b, err)
+	}
+	return rsp.StatusCode, out, rsp.Trailer
+}
+
+// 经过网关的请求同样需要token，grpc的错误码映射成HTTP状态码
+func TestGateway(t *testing.T) {
+	s := testutil.StartServer(t, testutil.WithAuth(token), testutil.WithGateway())
+	base := "http://" + s.Addr
+	auth := map[string]string{"Appid": token.AppId, "Appkey": token.AppKey}
+
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		header  map[string]string
+		status  int
+		message string // 成功时的回复
+	}{
+		{name: "post", method: http.MethodPost, path: "/v1/hello", body: `{"name":"foo"}`, header: auth, status: http.StatusOK, message: "Hello foo"},
+		{name: "get", method: http.MethodGet, path: "/v1/hello/bar", header: auth, status: http.StatusOK, message: "Hello bar"},
+		{name: "no token", method: http.MethodPost, path: "/v1/hello", body: `{"name":"foo"}`, status: http.StatusUnauthorized},
+		{name: "wrong key", method: http.MethodPost, path: "/v1/hello", body: `{"name":"foo"}`,
+			header: map[string]string{"Appid": token.AppId, "Appkey": "wrong"}, status: http.StatusUnauthorized},
+		{name: "invalid name", method: http.MethodPost, path: "/v1/hello", body: `{"name":"a-b"}`, header: auth, status: http.StatusBadRequest},
+		{name: "invalid json", method: http.MethodPost, path: "/v1/hello", body: `{"name":`, header: auth, status: http.StatusBadRequest},
+		{name: "not found", method: http.MethodPost, path: "/v1/nope", body: `{}`, header: auth, status: http.StatusNotFound},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			status, out, _ := request(t, c.method, base+c.path, c.body, c.header)
+			if status != c.status {
+				t.Fatalf("status = %d, want %d: %v", status, c.status, out)
+			}
+			if c.status == http.StatusOK && out["message"] != c.message {
+				t.Fatalf("message = %v, want %q", out["message"], c.message)
+			}
+			// 错误时返回序列化之后的status
+			if c.status != http.StatusOK && out["code"] == nil {
+				t.Fatalf("error without code: %v", out)
+			}
+		})
+	}
+	if got := s.Hello.Calls(); got != 2 {
+		t.Fatalf("calls = %d, want 2", got)
+	}
+}
+
+// 自定义的请求头透传到grpc的metadata：服务端沿用传进来的请求ID，并通过trailer带回来
+func TestGatewayForwardHeader(t *testing.T) {
+	s := testutil.StartServer(t, testutil.WithGateway())
+	status, out, trailer := request(t, http.MethodPost, "http://"+s.Addr+"/v1/hello", `{"name":"foo"}`,
+		map[string]string{"X-Request-Id": "gateway-id", "TE": "trailers"})
+	if status != http.StatusOK {
+		t.Fatalf("status = %d: %v", status, out)
+	}
+	if got := trailer.Get("Grpc-Trailer-" + common.MetaRequestId); got != "gateway-id" {
+		t.Fatalf("request id = %q, want %q", got, "gateway-id")
+	}
+	// ORCA的负载报告是二进制的metadata，不能作为HTTP头返回
+	for k := range trailer {
+		if strings.HasSuffix(strings.ToLower(k), "-bin") {
+			t.Fatalf("binary trailer %q returned", k)
+		}
+	}
+}
+
+// grpc和HTTP在同一个端口上
+func TestGatewaySamePort(t *testing.T) {
+	s := testutil.StartServer(t, testutil.WithAuth(token), testutil.WithGateway())
+	client := testutil.NewHelloClient(t, s.Target(), testutil.WithToken(token))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reply, err := client.SayHello(ctx, &pb.HelloRequest{Name: "grpc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Message != "Hello grpc" {
+		t.Fatalf("message = %q", reply.Message)
+	}
+
+	status, out, _ := request(t, http.MethodGet, "http://"+s.Addr+"/v1/hello/http", "",
+		map[string]string{"Appid": token.AppId, "Appkey": token.AppKey})
+	if status != http.StatusOK || out["message"] != "Hello http" {
+		t.Fatalf("status = %d: %v", status, out)
+	}
+}
+
+func TestHeaderMatcher(t *testing.T) {
+	cases := []struct {
+		header string
+		key    string
+		ok     bool
+	}{
+		{"Appid", "appid", true},
+		{"X-Request-Id", "x-request-id", true},
+		{"Grpc-Metadata-Foo", "Foo", true},
+		{"Authorization", "grpcgateway-Authorization", true},
+		{"Connection", "", false},
+		{"Transfer-Encoding", "", false},
+		{"Content-Length", "", false},
+	}
+	for _, c := range cases {
+		t.Run(c.header, func(t *testing.T) {
+			key, ok := gateway.HeaderMatcher(c.header)
+			if key != c.key || ok != c.ok {
+				t.Fatalf("HeaderMatcher(%q) = %q, %v, want %q, %v", c.header, key, ok, c.key, c.ok)
+			}
+		})
+	}
+}
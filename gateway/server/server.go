@@ -0,0 +1,68 @@
+/**
+ * 独立部署的HTTP/JSON网关
+ * 接收HTTP请求，转换成grpc请求发给后端，后端地址支持服务发现
+ *
+ * go run server.go -target 127.0.0.1:9090    # 其他配置见config包，比如 -config xxx.yaml
+ * go run server.go -target etcd:///myservicename_etcd
+ * curl -d '{"name":"foo"}' http://127.0.0.1:8080/v1/hello
+ * curl -H 'appid: 123' -H 'appkey: abc' http://127.0.0.1:8080/v1/hello/foo   # token认证的后端
+ */
+package main
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/health" // 开启客户端健康检查
+	"grpc-case/common"
+	"grpc-case/config"
+	_ "grpc-case/discovery/basic/client/resolver"    // 注册myscheme1:///
+	"grpc-case/discovery/etcd/client/resolver"       // 注册etcd:///
+	_ "grpc-case/discovery/failover/client/resolver" // 注册failover:///
+	_ "grpc-case/discovery/file/client/resolver"     // 注册file:///
+	_ "grpc-case/discovery/srv/client/resolver"      // 注册srv:///
+	"grpc-case/gateway"
+	"grpc-case/logging"
+	"grpc-case/middleware"
+	"net/http"
+)
+
+func main() {
+	// 加载配置，HTTP监听端口默认8080
+	cfg := config.MustLoad(
+		config.ForClient(),
+		config.Alias("http", "server.port"),
+		config.Alias("target", "client.target"),
+		config.WithDefaults(func(c *config.Config) { c.Server.Port = "8080" }),
+	)
+	resolver.Configure(cfg.Registry.Endpoints, cfg.Registry.DialTimeout)
+
+	// 后端的证书（默认不使用tls）
+	creds, err := cfg.TLS.ClientCredentials()
+	if err != nil {
+		panic(err)
+	}
+
+	// 连接后端的grpc服务
+	conn, err := grpc.NewClient(cfg.Client.Target,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultServiceConfig(common.GenServiceConfig(cfg.Balancer.Policy)),
+		// HTTP请求本身没有超时，转发时使用client.timeout；带了grpc-timeout头的请求，提前deadline_margin传给后端
+		grpc.WithChainUnaryInterceptor(middleware.DeadlineUnaryClientInterceptor(cfg.Client.DeadlineMargin, cfg.Client.Timeout)),
+	)
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	// 生成网关
+	h, err := gateway.NewHandler(context.Background(), conn)
+	if err != nil {
+		panic(err)
+	}
+
+	logging.L().Info("gateway start", zap.String("http", cfg.Server.ListenAddr()), zap.String("target", cfg.Client.Target))
+	if err := http.ListenAndServe(cfg.Server.ListenAddr(), h); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package loadreport
+
+/*
+ * 非unix系统不上报CPU使用率，只上报正在处理的请求数和业务自定义的利用率
+ */
+import "time"
+
+func processCPU() (time.Duration, bool) {
+	return 0, false
+}
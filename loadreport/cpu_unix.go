@@ -0,0 +1,19 @@
+//go:build unix
+
+package loadreport
+
+/*
+ * 进程累计使用的CPU时间（用户态 + 内核态）
+ */
+import (
+	"syscall"
+	"time"
+)
+
+func processCPU() (time.Duration, bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, false
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano()), true
+}
@@ -0,0 +1,116 @@
+/**
+ * 服务端负载上报（ORCA，Open Request Cost Aggregation）
+ * 上报的内容：
+ *  1. CPU使用率：进程的CPU时间 / (墙钟时间 * GOMAXPROCS)，由Run定时采样，只支持unix
+ *  2. 正在处理的请求数，作为named metric "inflight"，per-call上报的值包括当前这个请求
+ *  3. 业务自定义的利用率，通过Recorder()设置，比如 SetApplicationUtilization(0.8)、SetNamedUtilization("queue", 0.5)
+ * 上报的方式：
+ *  1. 每个请求的trailer（per-call），客户端的balancer在PickResult.Done中拿到，实时性最好
+ *  2. 带外的流（out-of-band，OpenRcaService.StreamCoreMetrics），客户端定时拉取，grpc限制最小间隔30秒，适合请求很少的连接
+ * 客户端按负载分配流量的balancer见balancer/orcabalancer
+ *
+ * 用法：
+ *  lr := loadreport.New()
+ *  s := bootstrap.NewServer(lr.ServerOptions()...)
+ *  lr.Register(s.Server)
+ *  go lr.Run(ctx, time.Second)
+ */
+package loadreport
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/orca"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// 正在处理的请求数在负载报告中的名字
+const InflightMetric = "inflight"
+
+type Reporter struct {
+	recorder orca.ServerMetricsRecorder
+	inflight atomic.Int64
+}
+
+func New() *Reporter {
+	return &Reporter{recorder: orca.NewServerMetricsRecorder()}
+}
+
+// 服务级别的负载，业务可以在这里设置自定义的利用率，会随每个请求以及带外的流一起上报
+func (r *Reporter) Recorder() orca.ServerMetricsRecorder {
+	return r.recorder
+}
+
+// 当前正在处理的请求数
+func (r *Reporter) Inflight() int64 {
+	return r.inflight.Load()
+}
+
+// 创建grpc服务时需要传入的选项：开启per-call上报，并统计正在处理的请求数
+func (r *Reporter) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		orca.CallMetricsServerOption(r),
+		grpc.ChainUnaryInterceptor(r.unaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(r.streamServerInterceptor()),
+	}
+}
+
+// 注册带外上报的服务
+func (r *Reporter) Register(s *grpc.Server) error {
+	return orca.Register(s, orca.ServiceOptions{ServerMetricsProvider: r})
+}
+
+// 实现orca.ServerMetricsProvider：业务设置的负载，加上当前正在处理的请求数
+func (r *Reporter) ServerMetrics() *orca.ServerMetrics {
+	sm := r.recorder.ServerMetrics()
+	sm.NamedMetrics[InflightMetric] = float64(r.inflight.Load())
+	return sm
+}
+
+// 每隔interval采样一次CPU使用率，阻塞直到ctx结束
+func (r *Reporter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastCPU, cpuOk := processCPU()
+	lastWall := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		if cpu, ok := processCPU(); ok && cpuOk {
+			wall := now.Sub(lastWall) * time.Duration(runtime.GOMAXPROCS(0))
+			if wall > 0 {
+				r.recorder.SetCPUUtilization(float64(cpu-lastCPU) / float64(wall))
+			}
+			lastCPU = cpu
+		}
+		lastWall = now
+	}
+}
+
+// 一元拦截器，需要在orca.CallMetricsServerOption之后（里层）
+// 取一次CallMetricsRecorder，orca才会在trailer中带上负载报告
+func (r *Reporter) unaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		n := r.inflight.Add(1)
+		defer r.inflight.Add(-1)
+		// 包括当前这个请求
+		orca.CallMetricsRecorderFromContext(ctx).SetNamedMetric(InflightMetric, float64(n))
+		return handler(ctx, req)
+	}
+}
+
+// 流式拦截器
+func (r *Reporter) streamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		n := r.inflight.Add(1)
+		defer r.inflight.Add(-1)
+		orca.CallMetricsRecorderFromContext(ss.Context()).SetNamedMetric(InflightMetric, float64(n))
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,123 @@
+package logging
+
+/*
+ * 日志拦截器：每个RPC结束之后打一行日志
+ * 记录 method、peer、tenant（appid）、code、latency、request_id
+ */
+import (
+	"context"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"grpc-case/common"
+	"time"
+)
+
+// 服务端一元拦截器
+func UnaryServerInterceptor(l *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		rsp, err := handler(ctx, req)
+		logCall(l, "server", info.FullMethod, serverFields(ctx), start, err)
+		return rsp, err
+	}
+}
+
+// 服务端流式拦截器
+func StreamServerInterceptor(l *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logCall(l, "server", info.FullMethod, serverFields(ss.Context()), start, err)
+		return err
+	}
+}
+
+// 客户端一元拦截器
+func UnaryClientInterceptor(l *zap.Logger) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		var p peer.Peer
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Peer(&p))...)
+		logCall(l, "client", method, clientFields(ctx, &p), start, err)
+		return err
+	}
+}
+
+// 客户端流式拦截器，只记录建立流的结果
+func StreamClientInterceptor(l *zap.Logger) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		var p peer.Peer
+		cs, err := streamer(ctx, desc, cc, method, append(opts, grpc.Peer(&p))...)
+		logCall(l, "client", method, clientFields(ctx, &p), start, err)
+		return cs, err
+	}
+}
+
+// 带上当前请求的request_id和tenant，在业务代码（handler）里打日志用
+func WithContext(ctx context.Context) *zap.Logger {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return L().With(metaFields(md)...)
+}
+
+func logCall(l *zap.Logger, kind, method string, fields []zap.Field, start time.Time, err error) {
+	code := status.Code(err)
+	fields = append(fields,
+		zap.String("kind", kind),
+		zap.String("method", method),
+		zap.String("code", code.String()),
+		zap.Duration("latency", time.Since(start)),
+	)
+	if err != nil {
+		fields = append(fields, zap.String("error", status.Convert(err).Message()))
+	}
+	if ce := l.Check(levelOf(code), "rpc finished"); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+// 服务端的问题用Error，调用方的问题用Warn
+func levelOf(code codes.Code) zapcore.Level {
+	switch code {
+	case codes.OK:
+		return zapcore.InfoLevel
+	case codes.Unknown, codes.Internal, codes.DataLoss, codes.Unimplemented:
+		return zapcore.ErrorLevel
+	default:
+		return zapcore.WarnLevel
+	}
+}
+
+func serverFields(ctx context.Context) []zap.Field {
+	var fields []zap.Field
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields = append(fields, zap.String("peer", p.Addr.String()))
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	return append(fields, metaFields(md)...)
+}
+
+func clientFields(ctx context.Context, p *peer.Peer) []zap.Field {
+	var fields []zap.Field
+	if p.Addr != nil {
+		fields = append(fields, zap.String("peer", p.Addr.String()))
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return append(fields, metaFields(md)...)
+}
+
+func metaFields(md metadata.MD) []zap.Field {
+	var fields []zap.Field
+	if v := md.Get(common.MetaAppId); len(v) > 0 {
+		fields = append(fields, zap.String("tenant", v[0]))
+	}
+	if v := md.Get(common.MetaRequestId); len(v) > 0 {
+		fields = append(fields, zap.String("request_id", v[0]))
+	}
+	return fields
+}
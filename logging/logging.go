@@ -0,0 +1,105 @@
+/**
+ * 日志
+ * 基于zap（etcd本身就依赖了zap），统一代替各处的fmt.Println
+ * 默认输出到标准输出，console格式，Info级别，可以通过环境变量GRPC_CASE_LOG_LEVEL调整级别
+ * 需要定制的话，用New生成一个logger，然后SetDefault替换掉默认的
+ * resolver、balancer等组件通过Named("resolver.etcd")取自己的logger，需要单独定制某个组件时用SetNamed注入
+ */
+package logging
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"sync"
+)
+
+const LevelEnv = "GRPC_CASE_LOG_LEVEL"
+
+var (
+	mu            sync.RWMutex
+	defaultLogger *zap.Logger
+	injected      = map[string]*zap.Logger{} // SetNamed注入的logger
+	derived       = map[string]*zap.Logger{} // 从默认logger派生的，替换默认logger之后重新生成
+)
+
+// 生成一个logger，level取值：debug/info/warn/error
+func New(level string) (*zap.Logger, error) {
+	lvl := zapcore.InfoLevel
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, err
+		}
+	}
+	cfg := zap.NewDevelopmentConfig()
+	cfg.Level = zap.NewAtomicLevelAt(lvl)
+	cfg.DisableStacktrace = true
+	cfg.OutputPaths = []string{"stdout"}
+	return cfg.Build()
+}
+
+// 返回默认的logger
+func L() *zap.Logger {
+	mu.RLock()
+	l := defaultLogger
+	mu.RUnlock()
+	if l != nil {
+		return l
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if defaultLogger == nil {
+		l, err := New(os.Getenv(LevelEnv))
+		if err != nil {
+			l, _ = New("")
+		}
+		defaultLogger = l
+	}
+	return defaultLogger
+}
+
+// 替换默认的logger
+func SetDefault(l *zap.Logger) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultLogger = l
+	clear(derived)
+}
+
+// 返回组件的logger：通过SetNamed注入过的直接使用，否则为L().Named(name)
+func Named(name string) *zap.Logger {
+	mu.RLock()
+	l, ok := injected[name]
+	if !ok {
+		l, ok = derived[name]
+	}
+	mu.RUnlock()
+	if ok {
+		return l
+	}
+
+	base := L()
+	mu.Lock()
+	defer mu.Unlock()
+	if l, ok := injected[name]; ok {
+		return l
+	}
+	l = base.Named(name)
+	// 生成的过程中默认logger被替换了，不缓存
+	if base == defaultLogger {
+		derived[name] = l
+	}
+	return l
+}
+
+// 给组件注入logger，需要在创建连接之前调用，l为nil时恢复使用L().Named(name)
+func SetNamed(name string, l *zap.Logger) {
+	mu.Lock()
+	defer mu.Unlock()
+	if l == nil {
+		delete(injected, name)
+		return
+	}
+	injected[name] = l
+}
@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"testing"
+)
+
+// 组件的logger跟随默认logger，SetNamed注入之后只影响这个组件，传nil恢复
+func TestNamed(t *testing.T) {
+	old := L()
+	t.Cleanup(func() { SetDefault(old) })
+
+	defCore, defLogs := observer.New(zapcore.DebugLevel)
+	SetDefault(zap.New(defCore))
+	Named("resolver.test").Info("default")
+	if entries := defLogs.FilterMessage("default").All(); len(entries) != 1 || entries[0].LoggerName != "resolver.test" {
+		t.Fatalf("default logger entries %v", entries)
+	}
+
+	injCore, injLogs := observer.New(zapcore.DebugLevel)
+	SetNamed("resolver.test", zap.New(injCore))
+	t.Cleanup(func() { SetNamed("resolver.test", nil) })
+	Named("resolver.test").Info("injected")
+	Named("balancer.test").Info("other")
+	if injLogs.FilterMessage("injected").Len() != 1 || defLogs.FilterMessage("injected").Len() != 0 {
+		t.Fatal("injected logger not used")
+	}
+	if defLogs.FilterMessage("other").Len() != 1 {
+		t.Fatal("other components should keep the default logger")
+	}
+
+	// 替换默认logger之后，没有注入的组件跟着换
+	newCore, newLogs := observer.New(zapcore.DebugLevel)
+	SetDefault(zap.New(newCore))
+	Named("balancer.test").Info("replaced")
+	if newLogs.FilterMessage("replaced").Len() != 1 {
+		t.Fatal("derived logger not refreshed after SetDefault")
+	}
+
+	SetNamed("resolver.test", nil)
+	Named("resolver.test").Info("restored")
+	if newLogs.FilterMessage("restored").Len() != 1 || injLogs.FilterMessage("restored").Len() != 0 {
+		t.Fatal("SetNamed(nil) did not restore the default logger")
+	}
+}
@@ -1,11 +1,14 @@
 /**
- *
+ * 默认运行context取消的演示
+ * go run . call ... 则是动态调用grpc方法的命令行工具（见cli包）
  */
 package main
 
 import (
 	"context"
 	"fmt"
+	"grpc-case/cli"
+	"os"
 	"time"
 )
 
@@ -39,6 +42,14 @@ func doSth(ctx context.Context) {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "call" {
+		if err := cli.Call(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	fmt.Println("start...")
 	someHandler()
 	fmt.Println("end.")
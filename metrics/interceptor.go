@@ -0,0 +1,58 @@
+package metrics
+
+/*
+ * RPC指标拦截器，按method、code统计次数和耗时
+ */
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// 服务端一元拦截器
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		rsp, err := handler(ctx, req)
+		observe(ServerHandled, ServerHandlingSeconds, info.FullMethod, start, err)
+		return rsp, err
+	}
+}
+
+// 服务端流式拦截器
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		observe(ServerHandled, ServerHandlingSeconds, info.FullMethod, start, err)
+		return err
+	}
+}
+
+// 客户端一元拦截器
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		observe(ClientHandled, ClientHandlingSeconds, method, start, err)
+		return err
+	}
+}
+
+// 客户端流式拦截器，只统计建立流的结果
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		observe(ClientHandled, ClientHandlingSeconds, method, start, err)
+		return cs, err
+	}
+}
+
+func observe(counter *prometheus.CounterVec, hist *prometheus.HistogramVec, method string, start time.Time, err error) {
+	code := status.Code(err).String()
+	counter.WithLabelValues(method, code).Inc()
+	hist.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+}
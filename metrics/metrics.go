@@ -0,0 +1,181 @@
+/**
+ * 监控指标
+ * 基于Prometheus，所有指标都注册在Registry上，通过HTTP的/metrics暴露出去
+ *  1. 服务端/客户端RPC：按method、code统计次数和耗时
+ *  2. resolver：每个target当前的地址数量，etcd watch事件的数量
+ *  3. balancer：每个SubConn（后端地址）被选中的次数
+ *  4. 服务端handler中panic被恢复的次数
+ *  5. 服务端并发限制：当前的限制值、正在处理的请求数、被拒绝的请求数
+ *  6. 服务端连接数，以及超过上限被拒绝的连接数
+ */
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net"
+	"net/http"
+)
+
+const Namespace = "grpc_case"
+
+// 独立的Registry，不使用prometheus的全局默认Registry
+var Registry = prometheus.NewRegistry()
+
+var (
+	ServerHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "server",
+		Name:      "handled_total",
+		Help:      "Total number of RPCs completed on the server.",
+	}, []string{"method", "code"})
+
+	ServerHandlingSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "server",
+		Name:      "handling_seconds",
+		Help:      "Latency of RPCs handled by the server.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	ServerConcurrencyLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "server",
+		Name:      "concurrency_limit",
+		Help:      "Current adaptive concurrency limit of the server.",
+	})
+
+	ServerInflight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "server",
+		Name:      "inflight",
+		Help:      "Number of RPCs admitted by the concurrency limiter and not yet finished.",
+	})
+
+	ServerShed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "server",
+		Name:      "shed_total",
+		Help:      "Number of RPCs rejected by the concurrency limiter.",
+	}, []string{"priority"})
+
+	ServerConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "server",
+		Name:      "connections",
+		Help:      "Number of open client connections counted against the connection limit.",
+	})
+
+	ServerRejectedConnections = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "server",
+		Name:      "rejected_connections_total",
+		Help:      "Number of connections closed because the connection limit was reached.",
+	})
+
+	ClientHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "client",
+		Name:      "handled_total",
+		Help:      "Total number of RPCs completed by the client.",
+	}, []string{"method", "code"})
+
+	ClientHandlingSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "client",
+		Name:      "handling_seconds",
+		Help:      "Latency of RPCs issued by the client.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	ResolverAddresses = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "resolver",
+		Name:      "addresses",
+		Help:      "Number of addresses currently resolved for a target.",
+	}, []string{"scheme", "target"})
+
+	ResolverWatchEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "resolver",
+		Name:      "etcd_watch_events_total",
+		Help:      "Number of etcd watch events received by the resolver.",
+	}, []string{"target", "type"})
+
+	ResolverSuppressedUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "resolver",
+		Name:      "suppressed_updates_total",
+		Help:      "Number of address updates merged into a later one by the resolver debounce.",
+	}, []string{"scheme", "target"})
+
+	ResolverPanicMode = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "resolver",
+		Name:      "panic_mode",
+		Help:      "1 if the resolver keeps serving the last good addresses because too many instances disappeared.",
+	}, []string{"scheme", "target"})
+
+	BalancerPicks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "balancer",
+		Name:      "picks_total",
+		Help:      "Number of times a SubConn was picked by the balancer.",
+	}, []string{"balancer", "addr"})
+
+	BalancerUtilization = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "balancer",
+		Name:      "backend_utilization",
+		Help:      "Latest utilization reported by a backend through ORCA load reports.",
+	}, []string{"balancer", "addr"})
+
+	PanicsRecovered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "server",
+		Name:      "panics_recovered_total",
+		Help:      "Number of panics recovered in server handlers.",
+	}, []string{"method"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ServerHandled,
+		ServerHandlingSeconds,
+		ServerConcurrencyLimit,
+		ServerInflight,
+		ServerShed,
+		ServerConnections,
+		ServerRejectedConnections,
+		ClientHandled,
+		ClientHandlingSeconds,
+		ResolverAddresses,
+		ResolverWatchEvents,
+		ResolverSuppressedUpdates,
+		ResolverPanicMode,
+		BalancerPicks,
+		BalancerUtilization,
+		PanicsRecovered,
+	)
+}
+
+// /metrics对应的Handler
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// 在addr上启动一个HTTP服务暴露/metrics，不阻塞
+// 返回实际监听的地址（addr可以是:0，由系统分配端口）
+func Serve(addr string) (net.Addr, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	go http.Serve(lis, mux)
+	return lis.Addr(), nil
+}
@@ -0,0 +1,109 @@
+package metrics_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"grpc-case/metrics"
+	"grpc-case/pb"
+	"grpc-case/testutil"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 抓取/metrics，返回name的样本中带有全部labels的那个的值
+func scrape(t *testing.T, url, name string, labels map[string]string) (float64, bool) {
+	t.Helper()
+	rsp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, name+"{") {
+			continue
+		}
+		matched := true
+		for k, v := range labels {
+			matched = matched && strings.Contains(line, fmt.Sprintf("%s=%q", k, v))
+		}
+		if !matched {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[strings.LastIndex(line, " ")+1:], 64)
+		if err != nil {
+			t.Fatalf("parse %q: %v", line, err)
+		}
+		return value, true
+	}
+	return 0, false
+}
+
+// 通过etcd resolver和my_balancer调用，服务端、客户端、resolver、balancer的指标都能从/metrics抓到
+func TestScrape(t *testing.T) {
+	addr, err := metrics.Serve("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + addr.String() + "/metrics"
+
+	const service = "hello_metrics"
+	reg := testutil.NewRegistry()
+	testutil.StartServer(t, testutil.WithRegistry(reg, service))
+	client := testutil.NewHelloClient(t, testutil.EtcdTarget(service), testutil.WithResolver(reg), testutil.WithBalancer("my_balancer"),
+		testutil.WithDialOptions(grpc.WithChainUnaryInterceptor(metrics.UnaryClientInterceptor())))
+	// 连接建立之后再上线一个实例，产生watch事件
+	testutil.StartServer(t, testutil.WithRegistry(reg, service))
+
+	const calls = 5
+	for i := 0; i < calls; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := client.SayHello(ctx, &pb.HelloRequest{Name: "metrics"})
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	method := pb.HelloService_SayHello_FullMethodName
+	cases := []struct {
+		name   string
+		labels map[string]string
+		min    float64
+	}{
+		{"grpc_case_server_handled_total", map[string]string{"method": method, "code": "OK"}, calls},
+		{"grpc_case_server_handling_seconds_count", map[string]string{"method": method, "code": "OK"}, calls},
+		{"grpc_case_client_handled_total", map[string]string{"method": method, "code": "OK"}, calls},
+		{"grpc_case_client_handling_seconds_count", map[string]string{"method": method, "code": "OK"}, calls},
+		{"grpc_case_resolver_addresses", map[string]string{"scheme": "etcd", "target": service}, 2},
+		{"grpc_case_resolver_etcd_watch_events_total", map[string]string{"target": service, "type": "PUT"}, 1},
+		{"grpc_case_balancer_picks_total", map[string]string{"balancer": "my_balancer"}, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// resolver的推送有合并窗口，地址数量可能稍晚一点才更新
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				v, ok := scrape(t, url, c.name, c.labels)
+				if ok && v >= c.min {
+					return
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("%s%v = %v (found %v), want >= %v", c.name, c.labels, v, ok, c.min)
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+		})
+	}
+}
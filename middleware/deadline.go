@@ -0,0 +1,85 @@
+package middleware
+
+/*
+ * 超时时间（deadline）的传递和检查
+ *  grpc本身会把ctx的deadline通过grpc-timeout头传给下游，服务端的ctx也会带上这个deadline，
+ *  所以只要服务端调用下游时用的是请求的ctx，超时就能一路传下去（main.go里演示的是单进程内的取消，这里是跨进程的）
+ *  在此基础上：
+ *  1. 服务端：剩余时间不足最低预算的请求直接拒绝，免得做了一半白做
+ *  2. 客户端：调用下游时预留一点时间（margin）给自己处理结果，剩余时间不够了就不再发出去
+ */
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcerrors "grpc-case/errors"
+	"time"
+)
+
+// 服务端一元拦截器：剩余时间小于min的请求直接返回DeadlineExceeded
+// 没有设置deadline的请求不受影响
+func MinBudgetUnaryServerInterceptor(min time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := checkBudget(ctx, min); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// 服务端流式拦截器
+func MinBudgetStreamServerInterceptor(min time.Duration) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkBudget(ss.Context(), min); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// 客户端一元拦截器：把ctx的deadline提前margin传给下游；ctx没有deadline时使用defaultTimeout（0表示不设置）
+func DeadlineUnaryClientInterceptor(margin, defaultTimeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, cancel, err := downstreamContext(ctx, margin, defaultTimeout)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// 剩余的时间预算，没有deadline时ok为false
+func Budget(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
+
+func checkBudget(ctx context.Context, min time.Duration) error {
+	if left, ok := Budget(ctx); ok && left < min {
+		return grpcerrors.New(codes.DeadlineExceeded, grpcerrors.ReasonDeadlineTooShort,
+			"insufficient deadline budget: %v left, need at least %v", left, min).Err()
+	}
+	return nil
+}
+
+func downstreamContext(ctx context.Context, margin, defaultTimeout time.Duration) (context.Context, context.CancelFunc, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		if defaultTimeout <= 0 {
+			return ctx, func() {}, nil
+		}
+		ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+		return ctx, cancel, nil
+	}
+	deadline = deadline.Add(-margin)
+	if !deadline.After(time.Now()) {
+		return nil, nil, grpcerrors.New(codes.DeadlineExceeded, grpcerrors.ReasonDeadlineTooShort,
+			"no deadline budget left for downstream call").Err()
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	return ctx, cancel, nil
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-case/pb"
+	"testing"
+	"time"
+)
+
+// 剩余时间不足最低预算的请求在handler之前被拒绝，返回DeadlineExceeded
+func TestMinBudget(t *testing.T) {
+	const min = 200 * time.Millisecond
+	var called bool
+	client := startHello(t, func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		called = true
+		return &pb.HelloReply{}, nil
+	}, grpc.UnaryInterceptor(MinBudgetUnaryServerInterceptor(min)))
+
+	cases := []struct {
+		name    string
+		timeout time.Duration // 0表示不设置deadline
+		code    codes.Code
+	}{
+		{"under budget", 50 * time.Millisecond, codes.DeadlineExceeded},
+		{"enough budget", 2 * time.Second, codes.OK},
+		{"no deadline", 0, codes.OK},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			called = false
+			ctx := context.Background()
+			if c.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, c.timeout)
+				defer cancel()
+			}
+			_, err := client.SayHello(ctx, &pb.HelloRequest{Name: "budget"})
+			if code := status.Code(err); code != c.code {
+				t.Fatalf("code = %v, want %v (%v)", code, c.code, err)
+			}
+			if called != (c.code == codes.OK) {
+				t.Fatalf("handler called = %v", called)
+			}
+		})
+	}
+}
+
+// 调用下游时deadline提前margin，没有deadline时使用默认的超时，剩余时间不够margin时不再发出去
+func TestDeadlineMargin(t *testing.T) {
+	const margin, defaultTimeout = 300 * time.Millisecond, time.Second
+	var budget time.Duration
+	var hasDeadline bool
+	client := startHello(t, func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		budget, hasDeadline = Budget(ctx)
+		return &pb.HelloReply{}, nil
+	}, grpc.EmptyServerOption{}, grpc.WithUnaryInterceptor(DeadlineUnaryClientInterceptor(margin, defaultTimeout)))
+
+	cases := []struct {
+		name    string
+		timeout time.Duration // 调用方的超时，0表示不设置
+		max     time.Duration // 服务端看到的剩余时间的上限
+		code    codes.Code
+	}{
+		{"shortened", 2 * time.Second, 2*time.Second - margin, codes.OK},
+		{"default timeout", 0, defaultTimeout, codes.OK},
+		{"no budget left", margin / 2, 0, codes.DeadlineExceeded},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			budget, hasDeadline = 0, false
+			ctx := context.Background()
+			if c.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, c.timeout)
+				defer cancel()
+			}
+			_, err := client.SayHello(ctx, &pb.HelloRequest{Name: "margin"})
+			if code := status.Code(err); code != c.code {
+				t.Fatalf("code = %v, want %v (%v)", code, c.code, err)
+			}
+			if c.code != codes.OK {
+				if hasDeadline {
+					t.Fatal("request sent without budget")
+				}
+				return
+			}
+			// 下限留出调用本身的耗时
+			if !hasDeadline || budget > c.max || budget < c.max-200*time.Millisecond {
+				t.Fatalf("server budget = %v (deadline %v), want about %v", budget, hasDeadline, c.max)
+			}
+		})
+	}
+}
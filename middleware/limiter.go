@@ -0,0 +1,279 @@
+package middleware
+
+/*
+ * 准入控制：自适应的并发限制
+ *  同时处理的请求数超过限制时直接拒绝（默认codes.ResourceExhausted，带RetryInfo），而不是让请求排队把延迟拖垮
+ *  限制值根据观察到的延迟自动调整，有两种算法：
+ *  1. gradient（默认）：长期平均延迟 / 当前延迟 作为梯度，延迟上升时按比例收缩，延迟正常时再加上一点余量（sqrt(limit)）慢慢增长
+ *  2. AIMD：请求超时或者失败时乘以backoff，否则加1
+ *  租户优先级：普通租户只能用到限制的(1-reserve)，剩下的留给高优先级的租户（token认证的appid），过载时先拒绝普通租户
+ *   限流在token校验之前执行，appid要和appkey一起校验通过才按高优先级处理，否则谁都可以冒充高优先级的租户
+ *  流式请求会占用并发数，但持续时间和负载无关，不参与延迟的统计
+ */
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"grpc-case/common"
+	grpcerrors "grpc-case/errors"
+	"grpc-case/metrics"
+	"math"
+	"sync"
+	"time"
+)
+
+// 请求的优先级
+type Priority int
+
+const (
+	PriorityNormal Priority = iota
+	PriorityHigh
+)
+
+func (p Priority) String() string {
+	if p == PriorityHigh {
+		return "high"
+	}
+	return "normal"
+}
+
+// 根据请求决定优先级
+type PriorityFunc func(ctx context.Context) Priority
+
+// 按token认证的appid判断优先级，appIds中的租户为高优先级
+// check校验请求的token（比如auth.Check），返回nil时才相信metadata中的appid；check为nil时全部是普通优先级
+func TenantPriority(check func(ctx context.Context) error, appIds ...string) PriorityFunc {
+	high := make(map[string]bool, len(appIds))
+	for _, id := range appIds {
+		high[id] = true
+	}
+	return func(ctx context.Context) Priority {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if v := md.Get(common.MetaAppId); len(v) > 0 && high[v[0]] && check != nil && check(ctx) == nil {
+			return PriorityHigh
+		}
+		return PriorityNormal
+	}
+}
+
+// 调整限制值的算法
+type limitAlgorithm interface {
+	// 一个请求结束时调用，返回新的限制值
+	update(limit float64, rtt time.Duration, inflight int, dropped bool) float64
+}
+
+type limiterOptions struct {
+	initial, min, max int
+	algo              limitAlgorithm
+	reserve           float64
+	priority          PriorityFunc
+	code              codes.Code
+	retryAfter        time.Duration
+}
+
+type LimiterOption func(*limiterOptions)
+
+// 限制值的初始值和范围，默认20，[1, 1000]
+func WithLimits(initial, min, max int) LimiterOption {
+	return func(o *limiterOptions) {
+		o.initial, o.min, o.max = initial, min, max
+	}
+}
+
+// 使用gradient算法（默认）
+// tolerance：延迟上升到长期平均的多少倍以内不收缩，默认1.5；smoothing：每次调整的平滑系数，默认0.2
+func WithGradient(tolerance, smoothing float64) LimiterOption {
+	return func(o *limiterOptions) {
+		o.algo = &gradientLimit{tolerance: tolerance, smoothing: smoothing}
+	}
+}
+
+// 使用AIMD算法：延迟超过timeout或者请求失败时乘以backoff（比如0.9），否则加1
+func WithAIMD(timeout time.Duration, backoff float64) LimiterOption {
+	return func(o *limiterOptions) {
+		o.algo = &aimdLimit{timeout: timeout, backoff: backoff}
+	}
+}
+
+// 给高优先级请求预留的比例，默认0.2，也就是普通请求最多用到限制的80%
+func WithReserve(reserve float64) LimiterOption {
+	return func(o *limiterOptions) {
+		o.reserve = reserve
+	}
+}
+
+// 判断请求的优先级，默认全部是普通优先级，一般用TenantPriority
+func WithPriorityFunc(fn PriorityFunc) LimiterOption {
+	return func(o *limiterOptions) {
+		o.priority = fn
+	}
+}
+
+// 拒绝时返回的错误码（ResourceExhausted或者Unavailable），以及建议客户端多久之后重试，默认ResourceExhausted、100ms
+// Note: 客户端的重试策略（retryPolicy）一般会重试Unavailable，过载时用它会把压力放大，除非客户端做了重试限流
+func WithRejectCode(code codes.Code, retryAfter time.Duration) LimiterOption {
+	return func(o *limiterOptions) {
+		o.code, o.retryAfter = code, retryAfter
+	}
+}
+
+// 自适应的并发限制，多个服务端拦截器可以共用一个
+type Limiter struct {
+	opts limiterOptions
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+}
+
+func NewLimiter(opts ...LimiterOption) *Limiter {
+	o := limiterOptions{
+		initial:    20,
+		min:        1,
+		max:        1000,
+		reserve:    0.2,
+		code:       codes.ResourceExhausted,
+		retryAfter: 100 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.algo == nil {
+		o.algo = &gradientLimit{tolerance: 1.5, smoothing: 0.2}
+	}
+	l := &Limiter{opts: o, limit: float64(o.initial)}
+	metrics.ServerConcurrencyLimit.Set(l.limit)
+	return l
+}
+
+// 当前的限制值
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// 当前正在处理的请求数
+func (l *Limiter) Inflight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inflight
+}
+
+// 申请一个并发名额，成功时返回释放函数
+func (l *Limiter) acquire(ctx context.Context) (func(rtt time.Duration, err error), error) {
+	p := PriorityNormal
+	if l.opts.priority != nil {
+		p = l.opts.priority(ctx)
+	}
+	l.mu.Lock()
+	allowed := l.limit
+	if p != PriorityHigh {
+		allowed = math.Max(1, l.limit*(1-l.opts.reserve))
+	}
+	if float64(l.inflight) >= allowed {
+		limit, inflight := int(l.limit), l.inflight
+		l.mu.Unlock()
+		metrics.ServerShed.WithLabelValues(p.String()).Inc()
+		return nil, grpcerrors.New(l.opts.code, grpcerrors.ReasonOverloaded, "server overloaded, %d of %d in flight", inflight, limit).
+			RetryAfter(l.opts.retryAfter).Err()
+	}
+	l.inflight++
+	metrics.ServerInflight.Set(float64(l.inflight))
+	l.mu.Unlock()
+
+	var once sync.Once
+	return func(rtt time.Duration, err error) {
+		once.Do(func() { l.release(rtt, err) })
+	}, nil
+}
+
+// 释放名额并根据这次请求的延迟调整限制值，rtt为0表示不参与统计
+func (l *Limiter) release(rtt time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if rtt > 0 {
+		switch status.Code(err) {
+		case codes.Canceled, codes.InvalidArgument, codes.Unauthenticated, codes.PermissionDenied, codes.NotFound:
+			// 客户端的问题，和服务端的负载无关
+		default:
+			dropped := status.Code(err) == codes.DeadlineExceeded || status.Code(err) == codes.Unavailable
+			limit := l.opts.algo.update(l.limit, rtt, l.inflight, dropped)
+			l.limit = math.Min(float64(l.opts.max), math.Max(float64(l.opts.min), limit))
+			metrics.ServerConcurrencyLimit.Set(l.limit)
+		}
+	}
+	l.inflight--
+	metrics.ServerInflight.Set(float64(l.inflight))
+}
+
+// 服务端一元拦截器：超过并发限制的请求直接拒绝
+// 名额在defer中释放，后面的拦截器或者handler panic时也不会一直占着
+func ConcurrencyLimitUnaryServerInterceptor(l *Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (rsp any, err error) {
+		release, err := l.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		defer func() { release(time.Since(start), err) }()
+		return handler(ctx, req)
+	}
+}
+
+// 服务端流式拦截器：只限制并发数，不参与延迟统计
+func ConcurrencyLimitStreamServerInterceptor(l *Limiter) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := l.acquire(ss.Context())
+		if err != nil {
+			return err
+		}
+		defer release(0, nil)
+		return handler(srv, ss)
+	}
+}
+
+// gradient算法，参考Netflix concurrency-limits的Gradient2
+type gradientLimit struct {
+	tolerance float64
+	smoothing float64
+	longRtt   float64 // 长期平均延迟（指数加权，约600个请求），单位纳秒
+}
+
+func (g *gradientLimit) update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	short := float64(rtt)
+	if g.longRtt == 0 {
+		g.longRtt = short
+	}
+	g.longRtt += (short - g.longRtt) * 2 / 601
+	// 延迟恢复之后长期平均下降得很慢，这里加快一点，免得限制值一直涨不回来
+	if g.longRtt/short > 2 {
+		g.longRtt *= 0.95
+	}
+	gradient := math.Max(0.5, math.Min(1, g.tolerance*g.longRtt/short))
+	newLimit := limit*gradient + math.Sqrt(limit)
+	newLimit = limit*(1-g.smoothing) + newLimit*g.smoothing
+	// 请求不多的时候不增长，否则空闲时限制值会涨到max，真正过载时来不及收缩
+	if newLimit > limit && float64(inflight) < limit/2 {
+		return limit
+	}
+	return newLimit
+}
+
+// AIMD算法
+type aimdLimit struct {
+	timeout time.Duration
+	backoff float64
+}
+
+func (a *aimdLimit) update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	if dropped || rtt > a.timeout {
+		return limit * a.backoff
+	}
+	if float64(inflight) >= limit/2 {
+		return limit + 1
+	}
+	return limit
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"grpc-case/common"
+	"testing"
+	"time"
+)
+
+var errBadToken = errors.New("bad token")
+
+// 只有appkey为secret时校验通过
+func checkToken(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(common.MetaAppKey); len(v) > 0 && v[0] == "secret" {
+		return nil
+	}
+	return errBadToken
+}
+
+func tenantCtx(appId, appKey string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(common.MetaAppId, appId, common.MetaAppKey, appKey))
+}
+
+func TestTenantPriority(t *testing.T) {
+	cases := []struct {
+		name  string
+		check func(context.Context) error
+		ctx   context.Context
+		want  Priority
+	}{
+		{"verified", checkToken, tenantCtx("vip", "secret"), PriorityHigh},
+		{"forged appid", checkToken, tenantCtx("vip", "guess"), PriorityNormal},
+		{"other tenant", checkToken, tenantCtx("other", "secret"), PriorityNormal},
+		{"no metadata", checkToken, context.Background(), PriorityNormal},
+		{"no check", nil, tenantCtx("vip", "secret"), PriorityNormal},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := TenantPriority(c.check, "vip")(c.ctx); got != c.want {
+				t.Fatalf("priority = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+// 占住n个名额的请求，返回之后调用release放行
+func hold(t *testing.T, call grpc.UnaryServerInterceptor, ctx context.Context, n int) (release func()) {
+	t.Helper()
+	block := make(chan struct{})
+	started := make(chan struct{}, n)
+	done := make(chan struct{}, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			call(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+				started <- struct{}{}
+				<-block
+				return nil, nil
+			})
+		}()
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatalf("only %d of %d calls admitted", i, n)
+		}
+	}
+	return func() {
+		close(block)
+		for i := 0; i < n; i++ {
+			<-done
+		}
+	}
+}
+
+func noop(ctx context.Context, req any) (any, error) {
+	return "ok", nil
+}
+
+// 超过限制的请求被拒绝，普通租户只能用到(1-reserve)，剩下的名额留给通过校验的高优先级租户
+func TestLimiterShed(t *testing.T) {
+	cases := []struct {
+		name     string
+		hold     context.Context // 先占满名额的请求
+		held     int
+		ctx      context.Context // 之后再来的请求
+		code     codes.Code
+		rejected bool
+	}{
+		{"under limit", tenantCtx("other", "secret"), 3, tenantCtx("other", "secret"), codes.ResourceExhausted, false},
+		{"normal over reserve", tenantCtx("other", "secret"), 4, tenantCtx("other", "secret"), codes.ResourceExhausted, true},
+		{"high uses reserve", tenantCtx("other", "secret"), 4, tenantCtx("vip", "secret"), codes.ResourceExhausted, false},
+		{"forged high", tenantCtx("other", "secret"), 4, tenantCtx("vip", "guess"), codes.ResourceExhausted, true},
+		{"high over limit", tenantCtx("vip", "secret"), 5, tenantCtx("vip", "secret"), codes.Unavailable, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			l := NewLimiter(WithLimits(5, 5, 5), WithReserve(0.2), WithRejectCode(c.code, time.Second),
+				WithPriorityFunc(TenantPriority(checkToken, "vip")))
+			call := ConcurrencyLimitUnaryServerInterceptor(l)
+			release := hold(t, call, c.hold, c.held)
+			_, err := call(c.ctx, nil, &grpc.UnaryServerInfo{}, noop)
+			release()
+			if c.rejected {
+				if status.Code(err) != c.code {
+					t.Fatalf("err = %v, want %v", err, c.code)
+				}
+			} else if err != nil {
+				t.Fatalf("rejected: %v", err)
+			}
+			if n := l.Inflight(); n != 0 {
+				t.Fatalf("inflight = %d after all calls returned", n)
+			}
+		})
+	}
+}
+
+// handler panic时名额也要释放
+func TestLimiterReleaseOnPanic(t *testing.T) {
+	l := NewLimiter(WithLimits(1, 1, 1))
+	call := ConcurrencyLimitUnaryServerInterceptor(l)
+	func() {
+		defer func() { recover() }()
+		call(context.Background(), nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+			panic("boom")
+		})
+	}()
+	if n := l.Inflight(); n != 0 {
+		t.Fatalf("inflight = %d after panic", n)
+	}
+	if _, err := call(context.Background(), nil, &grpc.UnaryServerInfo{}, noop); err != nil {
+		t.Fatalf("slot leaked: %v", err)
+	}
+
+	stream := ConcurrencyLimitStreamServerInterceptor(l)
+	var entered bool
+	func() {
+		defer func() { recover() }()
+		stream(nil, ctxStream{ctx: context.Background()}, &grpc.StreamServerInfo{}, func(srv any, ss grpc.ServerStream) error {
+			entered = true
+			panic("boom")
+		})
+	}()
+	if !entered {
+		t.Fatal("stream handler not called")
+	}
+	if n := l.Inflight(); n != 0 {
+		t.Fatalf("inflight = %d after stream panic", n)
+	}
+}
@@ -0,0 +1,138 @@
+package middleware
+
+/*
+ * panic恢复
+ *  handler里panic的话，默认会导致整个服务进程崩溃，这里把panic拦下来：
+ *  打印带request_id的堆栈日志、panic计数+1、调用自定义的钩子，然后给调用方返回codes.Internal
+ *  可选地在错误详情里带上一个脱敏后的DebugInfo（只有panic信息的第一行和函数名，没有文件路径）
+ */
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcerrors "grpc-case/errors"
+	"grpc-case/logging"
+	"grpc-case/metrics"
+	"runtime/debug"
+	"strings"
+	"unicode/utf8"
+)
+
+// panic发生时的钩子，比如上报到错误收集平台
+type RecoveryHook func(ctx context.Context, method string, p any, stack []byte)
+
+type recoveryOptions struct {
+	hook        RecoveryHook
+	debugDetail bool
+}
+
+type RecoveryOption func(*recoveryOptions)
+
+// 设置自定义的钩子
+func WithRecoveryHook(h RecoveryHook) RecoveryOption {
+	return func(o *recoveryOptions) {
+		o.hook = h
+	}
+}
+
+// 返回的错误中是否带上脱敏后的DebugInfo，线上一般不开
+func WithDebugDetail(on bool) RecoveryOption {
+	return func(o *recoveryOptions) {
+		o.debugDetail = on
+	}
+}
+
+// 服务端一元拦截器
+func RecoveryUnaryServerInterceptor(opts ...RecoveryOption) grpc.UnaryServerInterceptor {
+	o := newRecoveryOptions(opts)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (rsp any, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = o.recovered(ctx, info.FullMethod, p)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// 服务端流式拦截器
+func RecoveryStreamServerInterceptor(opts ...RecoveryOption) grpc.StreamServerInterceptor {
+	o := newRecoveryOptions(opts)
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = o.recovered(ss.Context(), info.FullMethod, p)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+func newRecoveryOptions(opts []RecoveryOption) *recoveryOptions {
+	o := &recoveryOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *recoveryOptions) recovered(ctx context.Context, method string, p any) error {
+	stack := debug.Stack()
+	logging.WithContext(ctx).Error("panic recovered",
+		zap.String("method", method), zap.Any("panic", p), zap.ByteString("stack", stack))
+	metrics.PanicsRecovered.WithLabelValues(method).Inc()
+	if o.hook != nil {
+		o.hook(ctx, method, p, stack)
+	}
+
+	b := grpcerrors.New(codes.Internal, grpcerrors.ReasonInternal, "internal error")
+	if o.debugDetail {
+		b.Detail(&errdetails.DebugInfo{
+			Detail:       sanitize(fmt.Sprint(p)),
+			StackEntries: stackFuncs(stack),
+		})
+	}
+	return b.Err()
+}
+
+// panic信息只保留第一行，并且限制长度
+// 按字符截断，不能把一个多字节的字符截成两半，否则不是合法的UTF-8，WithDetails序列化会失败
+func sanitize(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	if len(s) > 256 {
+		n := 256
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		s = s[:n] + "..."
+	}
+	return strings.ToValidUTF8(s, "?")
+}
+
+// 从debug.Stack()的输出中只取出函数名，去掉文件路径、参数地址等信息
+// 输出格式：第一行是goroutine信息，之后每两行一组：函数名(参数) / \t文件:行号
+// recover相关的帧（panic之前的部分）没有意义，也一并去掉
+func stackFuncs(stack []byte) []string {
+	lines := strings.Split(string(stack), "\n")
+	var funcs []string
+	for i := 1; i < len(lines); i += 2 {
+		f := lines[i]
+		if f == "" {
+			continue
+		}
+		if pos := strings.LastIndex(f, "("); pos > 0 {
+			f = f[:pos]
+		}
+		if f == "panic" {
+			funcs = funcs[:0]
+			continue
+		}
+		funcs = append(funcs, f)
+	}
+	return funcs
+}
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	grpcerrors "grpc-case/errors"
+	"grpc-case/metrics"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// 从metrics.Registry中取出method对应的panic计数
+func panicsRecovered(t *testing.T, method string) float64 {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != "grpc_case_server_panics_recovered_total" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "method" && l.GetValue() == method {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func debugInfo(err error) *errdetails.DebugInfo {
+	for _, d := range status.Convert(err).Details() {
+		if di, ok := d.(*errdetails.DebugInfo); ok {
+			return di
+		}
+	}
+	return nil
+}
+
+// handler中panic：返回Internal，调用钩子，计数+1；打开DebugInfo时，多字节的panic信息按字符截断，仍然是合法的UTF-8
+func TestRecovery(t *testing.T) {
+	long := strings.Repeat("中", 200) + "\nsecond line"
+	cases := []struct {
+		name   string
+		stream bool
+		panic  any
+		debug  bool
+		detail string // DebugInfo.Detail的前缀，空表示不带DebugInfo
+	}{
+		{name: "unary", panic: "boom"},
+		{name: "stream", stream: true, panic: "boom"},
+		{name: "unary debug", panic: "boom\nsecond line", debug: true, detail: "boom"},
+		{name: "multibyte", panic: long, debug: true, detail: strings.Repeat("中", 85)},
+		{name: "stream multibyte", stream: true, panic: long, debug: true, detail: strings.Repeat("中", 85)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			method := "/test.Recovery/" + strings.ReplaceAll(c.name, " ", "_")
+			var hooked any
+			opts := []RecoveryOption{
+				WithDebugDetail(c.debug),
+				WithRecoveryHook(func(ctx context.Context, m string, p any, stack []byte) {
+					if m != method || len(stack) == 0 {
+						t.Errorf("hook called with method %q, %d bytes stack", m, len(stack))
+					}
+					hooked = p
+				}),
+			}
+			before := panicsRecovered(t, method)
+
+			var err error
+			if c.stream {
+				err = RecoveryStreamServerInterceptor(opts...)(nil, ctxStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: method},
+					func(srv any, ss grpc.ServerStream) error { panic(c.panic) })
+			} else {
+				_, err = RecoveryUnaryServerInterceptor(opts...)(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method},
+					func(ctx context.Context, req any) (any, error) { panic(c.panic) })
+			}
+
+			if code := status.Code(err); code != codes.Internal {
+				t.Fatalf("code = %v, want Internal", code)
+			}
+			if reason := grpcerrors.Reason(err); reason != grpcerrors.ReasonInternal {
+				t.Fatalf("reason = %q, want %q", reason, grpcerrors.ReasonInternal)
+			}
+			if hooked != c.panic {
+				t.Fatalf("hook got %v, want %v", hooked, c.panic)
+			}
+			if got := panicsRecovered(t, method) - before; got != 1 {
+				t.Fatalf("panics recovered +%v, want +1", got)
+			}
+
+			di := debugInfo(err)
+			if c.detail == "" {
+				if di != nil {
+					t.Fatalf("unexpected DebugInfo %v", di)
+				}
+				return
+			}
+			if di == nil {
+				t.Fatal("DebugInfo missing")
+			}
+			if !utf8.ValidString(di.Detail) || !strings.HasPrefix(di.Detail, c.detail) || strings.Contains(di.Detail, "\n") {
+				t.Fatalf("detail = %q", di.Detail)
+			}
+			if len(di.Detail) > 256+len("...") {
+				t.Fatalf("detail not truncated, %d bytes", len(di.Detail))
+			}
+			for _, f := range di.StackEntries {
+				if strings.Contains(f, ".go:") {
+					t.Fatalf("stack entry %q contains file path", f)
+				}
+			}
+		})
+	}
+}
+
+// 按字符截断：256字节的边界落在多字节字符中间时往前退到字符的开头
+func TestSanitize(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"short", "boom", "boom"},
+		{"first line", "boom\nat main.go:10", "boom"},
+		{"ascii", strings.Repeat("a", 300), strings.Repeat("a", 256) + "..."},
+		// 3字节的字符，85个是255字节，第86个跨过了256
+		{"multibyte", strings.Repeat("中", 100), strings.Repeat("中", 85) + "..."},
+		{"offset", "a" + strings.Repeat("中", 100), "a" + strings.Repeat("中", 85) + "..."},
+		{"invalid", "bad\xffutf8", "bad?utf8"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := sanitize(c.in); got != c.want {
+				t.Fatalf("sanitize = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+type ctxStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s ctxStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,105 @@
+/**
+ * 通用的grpc中间件（拦截器）
+ */
+package middleware
+
+/*
+ * 请求ID（x-request-id）的生成和传递
+ *  服务端：上游带了就沿用，没带就生成一个；写回incoming metadata（日志拦截器就能取到），并在trailer中返回给调用方
+ *  客户端：outgoing metadata里没有的话，优先沿用当前请求（服务端处理中）的ID，否则生成一个新的
+ */
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"grpc-case/common"
+)
+
+// 生成一个新的请求ID
+func NewRequestId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 取出当前请求的ID：先看incoming（服务端），再看outgoing（客户端）
+func RequestId(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(common.MetaRequestId); len(v) > 0 {
+			return v[0]
+		}
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if v := md.Get(common.MetaRequestId); len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+// 服务端一元拦截器
+func RequestIdUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, id := ensureIncomingRequestId(ctx)
+		grpc.SetTrailer(ctx, metadata.Pairs(common.MetaRequestId, id))
+		return handler(ctx, req)
+	}
+}
+
+// 服务端流式拦截器
+func RequestIdStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, id := ensureIncomingRequestId(ss.Context())
+		ss.SetTrailer(metadata.Pairs(common.MetaRequestId, id))
+		return handler(srv, &wrappedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// 客户端一元拦截器
+func RequestIdUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(ensureOutgoingRequestId(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// 客户端流式拦截器
+func RequestIdStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(ensureOutgoingRequestId(ctx), desc, cc, method, opts...)
+	}
+}
+
+func ensureIncomingRequestId(ctx context.Context) (context.Context, string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(common.MetaRequestId); len(v) > 0 && v[0] != "" {
+		return ctx, v[0]
+	}
+	// metadata不能直接修改，复制一份再放回去
+	id := NewRequestId()
+	md = md.Copy()
+	md.Set(common.MetaRequestId, id)
+	return metadata.NewIncomingContext(ctx, md), id
+}
+
+func ensureOutgoingRequestId(ctx context.Context) context.Context {
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(common.MetaRequestId)) > 0 {
+		return ctx
+	}
+	id := RequestId(ctx)
+	if id == "" {
+		id = NewRequestId()
+	}
+	return metadata.AppendToOutgoingContext(ctx, common.MetaRequestId, id)
+}
+
+// 替换ServerStream的Context
+type wrappedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (w *wrappedStream) Context() context.Context {
+	return w.ctx
+}
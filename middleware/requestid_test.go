@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+	"grpc-case/common"
+	"grpc-case/pb"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// 处理函数可以替换的HelloService
+type helloFunc func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error)
+
+type helloServer struct {
+	pb.UnimplementedHelloServiceServer
+	fn helloFunc
+}
+
+func (s *helloServer) SayHello(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+	return s.fn(ctx, req)
+}
+
+// 在bufconn上启动带有指定拦截器的服务端，返回连接它的客户端
+func startHello(t *testing.T, fn helloFunc, serverOpt grpc.ServerOption, dialOpts ...grpc.DialOption) pb.HelloServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(serverOpt)
+	pb.RegisterHelloServiceServer(s, &helloServer{fn: fn})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	dialOpts = append(dialOpts,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+	)
+	conn, err := grpc.NewClient("passthrough:///bufnet", dialOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewHelloServiceClient(conn)
+}
+
+var requestIdPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// 服务端：没带请求ID时生成一个，带了就沿用，handler和日志都能从incoming metadata中取到，并通过trailer返回给调用方
+func TestRequestIdServer(t *testing.T) {
+	var seen string
+	client := startHello(t, func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		seen = RequestId(ctx)
+		return &pb.HelloReply{}, nil
+	}, grpc.UnaryInterceptor(RequestIdUnaryServerInterceptor()))
+
+	cases := []struct {
+		name string
+		id   string // 调用方带的请求ID
+	}{
+		{"generated", ""},
+		{"propagated", "upstream-id"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if c.id != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, common.MetaRequestId, c.id)
+			}
+			var trailer metadata.MD
+			if _, err := client.SayHello(ctx, &pb.HelloRequest{Name: "requestid"}, grpc.Trailer(&trailer)); err != nil {
+				t.Fatal(err)
+			}
+			if c.id != "" && seen != c.id {
+				t.Fatalf("handler saw %q, want %q", seen, c.id)
+			}
+			if c.id == "" && !requestIdPattern.MatchString(seen) {
+				t.Fatalf("generated request id %q", seen)
+			}
+			if got := trailer.Get(common.MetaRequestId); len(got) != 1 || got[0] != seen {
+				t.Fatalf("trailer %v, want %q", got, seen)
+			}
+		})
+	}
+}
+
+// 客户端：outgoing metadata里有就不动，服务端处理中的请求沿用上游的ID，都没有时生成新的
+func TestRequestIdClient(t *testing.T) {
+	var seen string
+	client := startHello(t, func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		seen = RequestId(ctx)
+		return &pb.HelloReply{}, nil
+	}, grpc.EmptyServerOption{}, grpc.WithUnaryInterceptor(RequestIdUnaryClientInterceptor()))
+
+	cases := []struct {
+		name string
+		ctx  func(ctx context.Context) context.Context
+		want string // 空表示生成的ID
+	}{
+		{"generated", func(ctx context.Context) context.Context { return ctx }, ""},
+		{"outgoing", func(ctx context.Context) context.Context {
+			return metadata.AppendToOutgoingContext(ctx, common.MetaRequestId, "outgoing-id")
+		}, "outgoing-id"},
+		{"from incoming", func(ctx context.Context) context.Context {
+			return metadata.NewIncomingContext(ctx, metadata.Pairs(common.MetaRequestId, "incoming-id"))
+		}, "incoming-id"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if _, err := client.SayHello(c.ctx(ctx), &pb.HelloRequest{Name: "requestid"}); err != nil {
+				t.Fatal(err)
+			}
+			if c.want != "" && seen != c.want {
+				t.Fatalf("server saw %q, want %q", seen, c.want)
+			}
+			if c.want == "" && !requestIdPattern.MatchString(seen) {
+				t.Fatalf("generated request id %q", seen)
+			}
+		})
+	}
+}
@@ -1,8 +1,14 @@
 #!/bin/bash
 
 # 生成代码
-protoc --go_out=. hello.proto           # 生成hello.pb.go，主要是一个结构的定义
-protoc --go-grpc_out=. hello.proto      # 生成hello_grpc.pb.go，主要是程序的框架
+#   hello.pb.go：      主要是一个结构的定义（--go_out）
+#   hello_grpc.pb.go： 主要是程序的框架（--go-grpc_out）
+#   hello.pb.gw.go：   HTTP/JSON网关的代码（--grpc-gateway_out）
+#   validate.pb.go：   字段校验规则的定义（hello.proto中通过字段option引用）
+# 网关插件需要先安装：go install github.com/grpc-ecosystem/grpc-gateway/v2/protoc-gen-grpc-gateway@latest
+# 依赖google/api/annotations.proto和http.proto，从 https://github.com/googleapis/googleapis 下载后用-I指定目录
+GOOGLEAPIS=${GOOGLEAPIS:-./third_party/googleapis}
+protoc -I . -I $GOOGLEAPIS --go_out=. --go-grpc_out=. --grpc-gateway_out=. hello.proto validate.proto
 
 # 参数：paths=source_relative，表示输出文件和输入文件位于同一个目录中
 #           =import，表示输出文件将存在在以Go软件包导入路径（go_package）命名的目录中
@@ -8,15 +8,46 @@ import (
 	"fmt"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
-	"grpc-case/common"
+	"grpc-case/config"
+	"grpc-case/logging"
+	"grpc-case/metrics"
+	"grpc-case/middleware"
 	"grpc-case/pb"
+	"grpc-case/tracing"
 )
 
+// go run client.go -client.target 127.0.0.1:9091
 func main() {
+	// 加载配置
+	cfg := config.MustLoad(config.Alias("target", "client.target"), config.Alias("metrics", "client.metrics_addr"))
+
+	// 链路追踪，是否导出由环境变量OTEL_TRACES_EXPORTER决定
+	shutdown, err := tracing.Init(context.Background(), "grpc-case-client")
+	if err != nil {
+		panic(err)
+	}
+	defer shutdown(context.Background())
+
+	// 暴露监控指标
+	if cfg.Client.MetricsAddr != "" {
+		if _, err := metrics.Serve(cfg.Client.MetricsAddr); err != nil {
+			panic(err)
+		}
+	}
+
 	// 创建链接，此处禁用了安全传输，用了一个假的证书，insecure.NewCredentials()，没有加密和验证
 	//conn, err := grpc.Dial(common.BackEnd0, grpc.WithTransportCredentials(insecure.NewCredentials()))   # 废弃
 	//conn, err := grpc.DialContext(context.Background(), common.BackEnd0, grpc.WithTransportCredentials(insecure.NewCredentials())) # 废弃
-	conn, err := grpc.NewClient(common.BackEnd0, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(cfg.Client.Target,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		tracing.DialOption(), // 链路追踪
+		grpc.WithChainUnaryInterceptor( // 请求ID、日志、监控、超时拦截器
+			middleware.RequestIdUnaryClientInterceptor(),
+			logging.UnaryClientInterceptor(logging.L()),
+			metrics.UnaryClientInterceptor(),
+			middleware.DeadlineUnaryClientInterceptor(cfg.Client.DeadlineMargin, cfg.Client.Timeout),
+		),
+	)
 	if err != nil {
 		panic(err)
 	}
@@ -26,7 +57,9 @@ func main() {
 	client := pb.NewHelloServiceClient(conn)
 
 	// 执行RPC调用
-	rsp, err := client.SayHello(context.Background(), &pb.HelloRequest{
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Client.Timeout)
+	defer cancel()
+	rsp, err := client.SayHello(ctx, &pb.HelloRequest{
 		Name: "foo",
 	})
 	if err != nil {
@@ -5,10 +5,12 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"google.golang.org/grpc"
-	"grpc-case/common"
+	"go.uber.org/zap"
+	"grpc-case/bootstrap"
+	"grpc-case/config"
+	"grpc-case/logging"
 	"grpc-case/pb"
+	"grpc-case/tracing"
 	"net"
 	"sync"
 )
@@ -20,22 +22,36 @@ type MyServer struct {
 
 // 实现业务代码
 func (m *MyServer) SayHello(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
-	fmt.Println("Recv Request:", req.Name)
+	logging.WithContext(ctx).Info("recv request", zap.String("name", req.Name))
 	return &pb.HelloReply{
 		Message: "Hello bar",
 	}, nil
 }
 
 // 服务启动起来
+// go run server.go -p 9091，也可以用 -config 指定配置文件，见config/example.yaml
 func main() {
+	// 加载配置
+	cfg := config.MustLoad(config.Alias("p", "server.port"))
+
+	// 链路追踪，是否导出由环境变量OTEL_TRACES_EXPORTER决定，每个进程初始化一次
+	shutdownTracing, err := tracing.Init(context.Background(), "grpc-case")
+	if err != nil {
+		panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// 创建监听端口
-	listener, err := net.Listen("tcp", ":"+common.BackEndPort0)
+	listener, err := net.Listen("tcp", cfg.Server.ListenAddr())
 	if err != nil {
 		panic(err)
 	}
 
 	// 创建grpc服务
-	grpcServer := grpc.NewServer()
+	grpcServer, err := bootstrap.NewServerFromConfig(cfg)
+	if err != nil {
+		panic(err)
+	}
 
 	// 在grpc服务中，注册业务自己的服务（也就是将自己的Server对象与grpc服务绑定）
 	pb.RegisterHelloServiceServer(grpcServer, &MyServer{})
@@ -44,7 +60,7 @@ func main() {
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
-		fmt.Println("Simple Server start!. Port:" + common.BackEndPort0)
+		logging.L().Info("simple server start", zap.String("addr", listener.Addr().String()))
 		defer wg.Done()
 		err = grpcServer.Serve(listener)
 		if err != nil {
@@ -0,0 +1,154 @@
+package testutil
+
+/*
+ * 测试用的客户端连接
+ */
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	_ "google.golang.org/grpc/health"   // 开启客户端健康检查
+	_ "grpc-case/balancer/mybalancer"   // 注册my_balancer
+	_ "grpc-case/balancer/orcabalancer" // 注册orca_weighted
+	"grpc-case/common"
+	"grpc-case/config"
+	"grpc-case/discovery/etcd/client/resolver"
+	"grpc-case/middleware"
+	"grpc-case/pb"
+	"grpc-case/token/auth"
+	"testing"
+	"time"
+)
+
+// 等待连接就绪的超时时间
+var ReadyTimeout = 5 * time.Second
+
+type clientOptions struct {
+	registry *Registry
+	policy   string
+	token    *config.AuthConfig
+	tls      bool
+	noWait   bool
+	dialOpts []grpc.DialOption
+
+	deadlineMargin, timeout time.Duration
+}
+
+type ClientOption func(*clientOptions)
+
+// etcd:///的地址从Registry中解析，只对这个连接生效
+func WithResolver(r *Registry) ClientOption {
+	return func(o *clientOptions) {
+		o.registry = r
+	}
+}
+
+// 负载均衡策略，默认round_robin
+func WithBalancer(policy string) ClientOption {
+	return func(o *clientOptions) {
+		o.policy = policy
+	}
+}
+
+// 每个请求带上appId/appKey，和token例子的客户端一样
+func WithToken(a config.AuthConfig) ClientOption {
+	return func(o *clientOptions) {
+		o.token = &a
+	}
+}
+
+// 使用TLS，信任WithTLS的服务端证书
+func WithClientTLS() ClientOption {
+	return func(o *clientOptions) {
+		o.tls = true
+	}
+}
+
+// 不等待连接就绪，比如测试没有可用实例时的行为
+func WithoutWait() ClientOption {
+	return func(o *clientOptions) {
+		o.noWait = true
+	}
+}
+
+// 调用时把ctx的deadline提前margin传给服务端，ctx没有deadline时使用timeout，默认和config.Default()一样
+func WithDeadline(margin, timeout time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.deadlineMargin, o.timeout = margin, timeout
+	}
+}
+
+// 其他的grpc选项，比如拦截器
+func WithDialOptions(opts ...grpc.DialOption) ClientOption {
+	return func(o *clientOptions) {
+		o.dialOpts = append(o.dialOpts, opts...)
+	}
+}
+
+// 创建连接并等待就绪，target可以是Server.Target()或者EtcdTarget(service)
+func Dial(t testing.TB, target string, opts ...ClientOption) *grpc.ClientConn {
+	t.Helper()
+	cc := config.Default().Client
+	o := clientOptions{policy: "round_robin", deadlineMargin: cc.DeadlineMargin, timeout: cc.Timeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	creds := insecure.NewCredentials()
+	if o.tls {
+		var err error
+		if creds, err = ClientTLS(); err != nil {
+			t.Fatalf("testutil: client tls: %v", err)
+		}
+	}
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithContextDialer(Dialer),
+		grpc.WithChainUnaryInterceptor(
+			middleware.RequestIdUnaryClientInterceptor(),
+			middleware.DeadlineUnaryClientInterceptor(o.deadlineMargin, o.timeout),
+		),
+		grpc.WithDefaultServiceConfig(common.GenServiceConfig(o.policy)),
+	}
+	if o.registry != nil {
+		dialOpts = append(dialOpts, grpc.WithResolvers(resolver.NewBuilder(o.registry.Client())))
+	}
+	if o.token != nil {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(&auth.Credentials{Auth: *o.token}))
+	}
+	conn, err := grpc.NewClient(target, append(dialOpts, o.dialOpts...)...)
+	if err != nil {
+		t.Fatalf("testutil: dial %s: %v", target, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if !o.noWait {
+		ctx, cancel := context.WithTimeout(context.Background(), ReadyTimeout)
+		defer cancel()
+		if err := WaitForReady(ctx, conn); err != nil {
+			t.Fatalf("testutil: wait for %s: %v", target, err)
+		}
+	}
+	return conn
+}
+
+// 创建连接并返回HelloService的客户端
+func NewHelloClient(t testing.TB, target string, opts ...ClientOption) pb.HelloServiceClient {
+	t.Helper()
+	return pb.NewHelloServiceClient(Dial(t, target, opts...))
+}
+
+// 等待连接变成READY，ctx结束时返回ctx的错误
+func WaitForReady(ctx context.Context, conn *grpc.ClientConn) error {
+	conn.Connect()
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return ctx.Err()
+		}
+	}
+}
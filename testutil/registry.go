@@ -0,0 +1,484 @@
+package testutil
+
+/*
+ * 内存实现的etcd，用来代替真实的etcd做服务注册和发现
+ *  Client()返回的*clientv3.Client只实现了KV、Watcher、Lease，etcd resolver和registrar用到的功能都支持：
+ *  前缀查询、从指定revision开始watch（历史事件会重放）、租约（撤销时删除关联的key）、压缩（watch已经被压缩的revision会收到ErrCompacted）
+ *  租约不会自动过期，需要时调用ExpireLeases模拟租约风暴或者etcd重启
+ *  PauseWatches、CancelWatches模拟watch中断，比如和etcd之间网络断开期间发生了压缩
+ */
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Registry struct {
+	mu         sync.Mutex
+	rev        int64
+	compactRev int64
+	kvs        map[string]*mvccpb.KeyValue
+	history    []*mvccpb.Event // 所有的事件，按revision排序，用于watch的重放
+	leases     map[clientv3.LeaseID]*memLease
+	nextLease  clientv3.LeaseID
+	watchers   map[*memWatch]struct{}
+
+	client *clientv3.Client
+}
+
+func NewRegistry() *Registry {
+	r := &Registry{
+		rev:      1,
+		kvs:      map[string]*mvccpb.KeyValue{},
+		leases:   map[clientv3.LeaseID]*memLease{},
+		watchers: map[*memWatch]struct{}{},
+	}
+	r.client = clientv3.NewCtxClient(context.Background())
+	r.client.KV = clientv3.NewKVFromKVClient(&memKV{r: r}, nil)
+	r.client.Watcher = &memWatcher{r: r}
+	r.client.Lease = &memLeaser{r: r}
+	return r
+}
+
+// 内存etcd的客户端，可以传给resolver.NewBuilder、registrar.New等
+func (r *Registry) Client() *clientv3.Client {
+	return r.client
+}
+
+// 直接写入一个key，比如模拟其他实例上线
+func (r *Registry) Put(key, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.put(key, value, clientv3.NoLease)
+}
+
+// 删除一个key，比如模拟实例下线
+func (r *Registry) Delete(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deleteRange(key, "")
+}
+
+// 前缀下所有的key，按字典序
+func (r *Registry) Keys(prefix string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var keys []string
+	for k := range r.kvs {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 压缩到当前的revision，之后从更早的revision开始的watch会失败
+func (r *Registry) Compact() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.compactRev = r.rev
+	r.history = nil
+}
+
+// 所有租约立即过期：删除关联的key，正在保活的channel关闭，registrar会重新注册
+func (r *Registry) ExpireLeases() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id := range r.leases {
+		r.revoke(id)
+	}
+}
+
+// 暂停所有的watch，之后的事件先积压起来不发出去，模拟客户端和etcd之间的网络断开
+func (r *Registry) PauseWatches() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for w := range r.watchers {
+		w.mu.Lock()
+		w.paused = true
+		w.mu.Unlock()
+	}
+}
+
+// 取消所有的watch，积压的事件丢弃，watch收到Canceled的响应后关闭
+// 之前调用过Compact时响应带上CompactRevision（ErrCompacted），和etcd取消一个落后于压缩点的watch一样
+func (r *Registry) CancelWatches() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for w := range r.watchers {
+		w.cancel(r.compactRev)
+		delete(r.watchers, w)
+	}
+}
+
+// 以下的方法都需要持有锁
+
+func (r *Registry) header() *etcdserverpb.ResponseHeader {
+	return &etcdserverpb.ResponseHeader{Revision: r.rev}
+}
+
+func (r *Registry) put(key, value string, lease clientv3.LeaseID) *mvccpb.KeyValue {
+	r.rev++
+	prev := r.kvs[key]
+	kv := &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), ModRevision: r.rev, Version: 1, Lease: int64(lease)}
+	if prev != nil {
+		kv.CreateRevision, kv.Version = prev.CreateRevision, prev.Version+1
+		if l := r.leases[clientv3.LeaseID(prev.Lease)]; l != nil {
+			delete(l.keys, key)
+		}
+	} else {
+		kv.CreateRevision = r.rev
+	}
+	r.kvs[key] = kv
+	if l := r.leases[lease]; l != nil {
+		l.keys[key] = true
+	}
+	r.publish(&mvccpb.Event{Type: mvccpb.PUT, Kv: kv, PrevKv: prev})
+	return prev
+}
+
+// 删除[key, end)范围内的key，end为空表示只删除key
+func (r *Registry) deleteRange(key, end string) []*mvccpb.KeyValue {
+	var deleted []*mvccpb.KeyValue
+	for _, k := range r.rangeKeys(key, end) {
+		prev := r.kvs[k]
+		delete(r.kvs, k)
+		if l := r.leases[clientv3.LeaseID(prev.Lease)]; l != nil {
+			delete(l.keys, k)
+		}
+		r.rev++
+		r.publish(&mvccpb.Event{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: []byte(k), ModRevision: r.rev}, PrevKv: prev})
+		deleted = append(deleted, prev)
+	}
+	return deleted
+}
+
+// [key, end)范围内的key，按字典序；end为"\x00"表示key之后的所有key
+func (r *Registry) rangeKeys(key, end string) []string {
+	var keys []string
+	for k := range r.kvs {
+		if inRange(k, key, end) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func inRange(k, key, end string) bool {
+	switch end {
+	case "":
+		return k == key
+	case "\x00":
+		return k >= key
+	}
+	return k >= key && k < end
+}
+
+func (r *Registry) publish(ev *mvccpb.Event) {
+	r.history = append(r.history, ev)
+	for w := range r.watchers {
+		w.send(ev)
+	}
+}
+
+func (r *Registry) revoke(id clientv3.LeaseID) bool {
+	l, ok := r.leases[id]
+	if !ok {
+		return false
+	}
+	delete(r.leases, id)
+	keys := make([]string, 0, len(l.keys))
+	for k := range l.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		r.deleteRange(k, "")
+	}
+	close(l.done)
+	return true
+}
+
+// KV：实现etcd的KV服务（etcdserverpb.KVClient），再通过clientv3.NewKVFromKVClient包装成客户端
+// clientv3把Put的选项（比如租约）转成请求中的字段，这里直接从请求中取
+type memKV struct {
+	r *Registry
+}
+
+func (kv *memKV) Range(ctx context.Context, req *etcdserverpb.RangeRequest, opts ...grpc.CallOption) (*etcdserverpb.RangeResponse, error) {
+	kv.r.mu.Lock()
+	defer kv.r.mu.Unlock()
+	resp := &etcdserverpb.RangeResponse{Header: kv.r.header()}
+	for _, k := range kv.r.rangeKeys(string(req.Key), string(req.RangeEnd)) {
+		resp.Kvs = append(resp.Kvs, kv.r.kvs[k])
+	}
+	resp.Count = int64(len(resp.Kvs))
+	return resp, nil
+}
+
+func (kv *memKV) Put(ctx context.Context, req *etcdserverpb.PutRequest, opts ...grpc.CallOption) (*etcdserverpb.PutResponse, error) {
+	kv.r.mu.Lock()
+	defer kv.r.mu.Unlock()
+	lease := clientv3.LeaseID(req.Lease)
+	if lease != clientv3.NoLease && kv.r.leases[lease] == nil {
+		return nil, rpctypes.ErrGRPCLeaseNotFound
+	}
+	prev := kv.r.put(string(req.Key), string(req.Value), lease)
+	resp := &etcdserverpb.PutResponse{Header: kv.r.header()}
+	if req.PrevKv {
+		resp.PrevKv = prev
+	}
+	return resp, nil
+}
+
+func (kv *memKV) DeleteRange(ctx context.Context, req *etcdserverpb.DeleteRangeRequest, opts ...grpc.CallOption) (*etcdserverpb.DeleteRangeResponse, error) {
+	kv.r.mu.Lock()
+	defer kv.r.mu.Unlock()
+	deleted := kv.r.deleteRange(string(req.Key), string(req.RangeEnd))
+	resp := &etcdserverpb.DeleteRangeResponse{Header: kv.r.header(), Deleted: int64(len(deleted))}
+	if req.PrevKv {
+		resp.PrevKvs = deleted
+	}
+	return resp, nil
+}
+
+// 不支持事务
+func (kv *memKV) Txn(ctx context.Context, req *etcdserverpb.TxnRequest, opts ...grpc.CallOption) (*etcdserverpb.TxnResponse, error) {
+	return nil, errors.New("testutil: Txn is not supported")
+}
+
+func (kv *memKV) Compact(ctx context.Context, req *etcdserverpb.CompactionRequest, opts ...grpc.CallOption) (*etcdserverpb.CompactionResponse, error) {
+	kv.r.Compact()
+	return &etcdserverpb.CompactionResponse{}, nil
+}
+
+// Watcher
+type memWatcher struct {
+	r *Registry
+}
+
+func (mw *memWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	op := clientv3.OpGet(key, opts...)
+	out := make(chan clientv3.WatchResponse)
+	w := &memWatch{key: key, end: string(op.RangeBytes()), notify: make(chan struct{}, 1)}
+
+	r := mw.r
+	r.mu.Lock()
+	if rev := op.Rev(); rev > 0 {
+		if rev <= r.compactRev {
+			compactRev := r.compactRev
+			r.mu.Unlock()
+			go func() {
+				defer close(out)
+				select {
+				case out <- clientv3.WatchResponse{CompactRevision: compactRev, Canceled: true}:
+				case <-ctx.Done():
+				}
+			}()
+			return out
+		}
+		for _, ev := range r.history {
+			if ev.Kv.ModRevision >= rev {
+				w.send(ev)
+			}
+		}
+	}
+	r.watchers[w] = struct{}{}
+	r.mu.Unlock()
+
+	go func() {
+		defer close(out)
+		defer func() {
+			r.mu.Lock()
+			delete(r.watchers, w)
+			r.mu.Unlock()
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.notify:
+			}
+			resps, canceled := w.take()
+			if canceled != nil {
+				resps = append(resps, *canceled)
+			}
+			for _, resp := range resps {
+				select {
+				case out <- resp:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if canceled != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (mw *memWatcher) RequestProgress(ctx context.Context) error {
+	return nil
+}
+
+func (mw *memWatcher) Close() error {
+	return nil
+}
+
+// 一个watch，事件先放进队列，由单独的goroutine发出去，不会阻塞写入
+type memWatch struct {
+	key, end string
+	mu       sync.Mutex
+	pending  []clientv3.WatchResponse
+	paused   bool                    // 暂停时事件留在pending中
+	canceled *clientv3.WatchResponse // 不为nil时发出这个响应后关闭
+	notify   chan struct{}
+}
+
+func (w *memWatch) send(ev *mvccpb.Event) {
+	if !inRange(string(ev.Kv.Key), w.key, w.end) {
+		return
+	}
+	w.mu.Lock()
+	w.pending = append(w.pending, clientv3.WatchResponse{
+		Header: etcdserverpb.ResponseHeader{Revision: ev.Kv.ModRevision},
+		Events: []*clientv3.Event{(*clientv3.Event)(ev)},
+	})
+	w.mu.Unlock()
+	w.wakeup()
+}
+
+func (w *memWatch) cancel(compactRev int64) {
+	w.mu.Lock()
+	w.pending, w.paused = nil, false
+	w.canceled = &clientv3.WatchResponse{CompactRevision: compactRev, Canceled: true}
+	w.mu.Unlock()
+	w.wakeup()
+}
+
+func (w *memWatch) wakeup() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// 取出要发出的响应，暂停时不取；canceled不为nil表示之后关闭这个watch
+func (w *memWatch) take() (pending []clientv3.WatchResponse, canceled *clientv3.WatchResponse) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.canceled != nil {
+		return nil, w.canceled
+	}
+	if w.paused {
+		return nil, nil
+	}
+	pending = w.pending
+	w.pending = nil
+	return pending, nil
+}
+
+// Lease
+type memLease struct {
+	ttl  int64
+	keys map[string]bool
+	done chan struct{} // 撤销或者过期时关闭
+}
+
+type memLeaser struct {
+	r *Registry
+}
+
+func (ml *memLeaser) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	r := ml.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextLease++
+	r.leases[r.nextLease] = &memLease{ttl: ttl, keys: map[string]bool{}, done: make(chan struct{})}
+	return &clientv3.LeaseGrantResponse{ResponseHeader: r.header(), ID: r.nextLease, TTL: ttl}, nil
+}
+
+func (ml *memLeaser) Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+	r := ml.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.revoke(id) {
+		return nil, rpctypes.ErrLeaseNotFound
+	}
+	return &clientv3.LeaseRevokeResponse{Header: r.header()}, nil
+}
+
+func (ml *memLeaser) TimeToLive(ctx context.Context, id clientv3.LeaseID, opts ...clientv3.LeaseOption) (*clientv3.LeaseTimeToLiveResponse, error) {
+	r := ml.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.leases[id]
+	if !ok {
+		return &clientv3.LeaseTimeToLiveResponse{ResponseHeader: r.header(), ID: id, TTL: -1}, nil
+	}
+	resp := &clientv3.LeaseTimeToLiveResponse{ResponseHeader: r.header(), ID: id, TTL: l.ttl, GrantedTTL: l.ttl}
+	for k := range l.keys {
+		resp.Keys = append(resp.Keys, []byte(k))
+	}
+	sort.Slice(resp.Keys, func(i, j int) bool { return bytes.Compare(resp.Keys[i], resp.Keys[j]) < 0 })
+	return resp, nil
+}
+
+func (ml *memLeaser) Leases(ctx context.Context) (*clientv3.LeaseLeasesResponse, error) {
+	r := ml.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	resp := &clientv3.LeaseLeasesResponse{ResponseHeader: r.header()}
+	for id := range r.leases {
+		resp.Leases = append(resp.Leases, clientv3.LeaseStatus{ID: id})
+	}
+	return resp, nil
+}
+
+// 保活：立即回复一次，之后channel一直保持打开，直到ctx结束或者租约被撤销、过期
+func (ml *memLeaser) KeepAlive(ctx context.Context, id clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	r := ml.r
+	r.mu.Lock()
+	l, ok := r.leases[id]
+	header := r.header()
+	r.mu.Unlock()
+	if !ok {
+		return nil, rpctypes.ErrLeaseNotFound
+	}
+	ch := make(chan *clientv3.LeaseKeepAliveResponse, 1)
+	ch <- &clientv3.LeaseKeepAliveResponse{ResponseHeader: header, ID: id, TTL: l.ttl}
+	go func() {
+		defer close(ch)
+		select {
+		case <-ctx.Done():
+		case <-l.done:
+		}
+	}()
+	return ch, nil
+}
+
+func (ml *memLeaser) KeepAliveOnce(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseKeepAliveResponse, error) {
+	r := ml.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.leases[id]
+	if !ok {
+		return nil, rpctypes.ErrLeaseNotFound
+	}
+	return &clientv3.LeaseKeepAliveResponse{ResponseHeader: r.header(), ID: id, TTL: l.ttl}, nil
+}
+
+func (ml *memLeaser) Close() error {
+	return nil
+}
@@ -0,0 +1,324 @@
+/**
+ * 进程内的测试工具，不需要另外启动服务端进程和etcd
+ *  StartServer：启动一个HelloService服务端，通过选项组合出各个例子的服务端：
+ *   1. simple：默认
+ *   2. tls：WithTLS
+ *   3. token：WithAuth
+ *   4. etcd注册：WithRegistry，注册到内存实现的etcd（见Registry）
+ *   默认监听bufconn（内存中的连接），WithTCP监听127.0.0.1上的随机端口
+ *   WithGateway：和token例子的服务端一样，在同一个端口上同时提供HTTP/JSON网关（见gateway.ServeMux）
+ *  Dial：创建客户端连接并等待连接就绪，WithResolver指定从哪个Registry解析etcd:///的地址，WithToken、WithClientTLS对应token和tls的例子
+ * 服务端和连接都在测试结束时（t.Cleanup）自动关闭
+ */
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"grpc-case/bootstrap"
+	"grpc-case/common"
+	"grpc-case/config"
+	"grpc-case/discovery/etcd/server/registrar"
+	"grpc-case/gateway"
+	"grpc-case/loadreport"
+	"grpc-case/middleware"
+	"grpc-case/pb"
+	"grpc-case/token/auth"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// bufconn的缓冲区大小
+const bufSize = 1 << 20
+
+// 所有bufconn的监听，地址 => 监听，客户端拨号时按地址找到对应的监听
+var (
+	bufMu        sync.Mutex
+	bufListeners = map[string]*bufconn.Listener{}
+	bufSeq       int
+)
+
+// 拨号：bufconn的地址直接连到内存中的监听，其他地址按tcp拨号
+// 注册到Registry中的也是这个地址，所以etcd:///的连接同样可以用bufconn
+func Dialer(ctx context.Context, addr string) (net.Conn, error) {
+	bufMu.Lock()
+	lis, ok := bufListeners[addr]
+	bufMu.Unlock()
+	if ok {
+		return lis.DialContext(ctx)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+func listenBuf() (string, net.Listener) {
+	bufMu.Lock()
+	defer bufMu.Unlock()
+	bufSeq++
+	addr := fmt.Sprintf("bufnet-%d", bufSeq)
+	lis := bufconn.Listen(bufSize)
+	bufListeners[addr] = lis
+	return addr, lis
+}
+
+func closeBuf(addr string) {
+	bufMu.Lock()
+	defer bufMu.Unlock()
+	delete(bufListeners, addr)
+}
+
+type serverOptions struct {
+	tls      bool
+	auth     bool
+	tcp      bool
+	gateway  bool
+	registry *Registry
+	service  string
+	configFn func(*config.Config)
+}
+
+type ServerOption func(*serverOptions)
+
+// 使用TLS（进程内生成的自签名证书），客户端需要Dial时加上WithClientTLS
+func WithTLS() ServerOption {
+	return func(o *serverOptions) {
+		o.tls = true
+	}
+}
+
+// 开启token校验，和token例子的服务端一样校验appId/appKey
+func WithAuth(a config.AuthConfig) ServerOption {
+	return func(o *serverOptions) {
+		o.auth = true
+		o.configFn = chainConfig(o.configFn, func(c *config.Config) { c.Auth = a })
+	}
+}
+
+// 注册到Registry，服务名为service，客户端通过etcd:///service访问
+func WithRegistry(r *Registry, service string) ServerOption {
+	return func(o *serverOptions) {
+		o.registry, o.service = r, service
+	}
+}
+
+// 监听127.0.0.1上的随机端口，而不是bufconn
+func WithTCP() ServerOption {
+	return func(o *serverOptions) {
+		o.tcp = true
+	}
+}
+
+// 同一个端口上同时提供grpc和HTTP网关，HTTP请求转成grpc请求再发给自己，地址是http://Addr
+// cmux需要真实的连接，所以同时会监听tcp，不能和WithTLS一起使用（开启TLS时网关单独监听端口）
+func WithGateway() ServerOption {
+	return func(o *serverOptions) {
+		o.gateway, o.tcp = true, true
+	}
+}
+
+// 修改服务端的配置，比如开启并发限制、设置keepalive
+func WithConfig(fn func(*config.Config)) ServerOption {
+	return func(o *serverOptions) {
+		o.configFn = chainConfig(o.configFn, fn)
+	}
+}
+
+func chainConfig(a, b func(*config.Config)) func(*config.Config) {
+	if a == nil {
+		return b
+	}
+	return func(c *config.Config) {
+		a(c)
+		b(c)
+	}
+}
+
+// 测试中启动的服务端
+type Server struct {
+	*bootstrap.Server
+	Addr  string               // 监听的地址，bufconn时是bufnet-N
+	Key   string               // 在Registry中注册的key，没有注册时为空
+	Hello *HelloServer         // 业务实现，可以查看收到的请求数
+	Load  *loadreport.Reporter // ORCA负载上报，测试中可以直接设置CPU等指标
+
+	gwConn    *grpc.ClientConn // 网关连自己的连接
+	stopReg   context.CancelFunc
+	regDone   chan struct{}
+	serveDone chan struct{}
+	closeOnce sync.Once
+}
+
+// 启动服务端，返回时已经开始Serve，注册到Registry时已经注册成功
+func StartServer(t testing.TB, opts ...ServerOption) *Server {
+	t.Helper()
+	var o serverOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	cfg := config.Default()
+	cfg.Server.DrainDelay = 0
+	if o.configFn != nil {
+		o.configFn(cfg)
+	}
+	if o.gateway && o.tls {
+		t.Fatal("testutil: WithGateway can not be used with WithTLS")
+	}
+
+	lr := loadreport.New()
+	sopts := lr.ServerOptions()
+	if o.tls {
+		creds, err := ServerTLS()
+		if err != nil {
+			t.Fatalf("testutil: server tls: %v", err)
+		}
+		sopts = append(sopts, grpc.Creds(creds))
+	}
+	gs, err := bootstrap.NewServerFromConfig(cfg, sopts...)
+	if err != nil {
+		t.Fatalf("testutil: create server: %v", err)
+	}
+	if err := lr.Register(gs.Server); err != nil {
+		t.Fatalf("testutil: register load report: %v", err)
+	}
+	hello := &HelloServer{}
+	if o.auth {
+		hello.Auth = &cfg.Auth
+	}
+	pb.RegisterHelloServiceServer(gs, hello)
+
+	var addr string
+	var lis net.Listener
+	if o.tcp {
+		if lis, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			t.Fatalf("testutil: listen: %v", err)
+		}
+		addr = lis.Addr().String()
+	} else {
+		addr, lis = listenBuf()
+	}
+
+	s := &Server{Server: gs, Addr: addr, Hello: hello, Load: lr, serveDone: make(chan struct{})}
+	var h http.Handler
+	if o.gateway {
+		if h, err = s.newGateway(cfg); err != nil {
+			lis.Close()
+			t.Fatalf("testutil: create gateway: %v", err)
+		}
+	}
+	go func() {
+		defer close(s.serveDone)
+		if h != nil {
+			gateway.ServeMux(lis, gs, h)
+			return
+		}
+		gs.Serve(lis)
+	}()
+	t.Cleanup(s.Close)
+
+	if o.registry != nil {
+		s.register(t, o.registry, o.service, cfg.Registry.LeaseTTL)
+	}
+	return s
+}
+
+// 和token例子的服务端一样，网关通过带超时拦截器的连接把请求发给自己
+func (s *Server) newGateway(cfg *config.Config) (http.Handler, error) {
+	conn, err := grpc.NewClient(s.Addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(middleware.DeadlineUnaryClientInterceptor(cfg.Client.DeadlineMargin, cfg.Client.Timeout)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	h, err := gateway.NewHandler(context.Background(), conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s.gwConn = conn
+	return h, nil
+}
+
+// 注册到Registry，等到第一次注册成功再返回
+func (s *Server) register(t testing.TB, r *Registry, service string, ttl int64) {
+	t.Helper()
+	s.Key = common.GenInstancePath(common.MySchemeEtcd, service, s.Addr)
+	registered := make(chan struct{})
+	var once sync.Once
+	reg := registrar.New(r.Client(), s.Key, s.Addr,
+		registrar.WithTTL(ttl),
+		registrar.WithBackoff(10*time.Millisecond, 100*time.Millisecond), // 租约过期之后很快重新注册
+		registrar.WithStateFunc(func(state registrar.State, err error) {
+			if state == registrar.Registered {
+				once.Do(func() { close(registered) })
+			}
+		}),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopReg, s.regDone = cancel, make(chan struct{})
+	go func() {
+		defer close(s.regDone)
+		reg.Run(ctx)
+	}()
+	select {
+	case <-registered:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("testutil: register %s timeout", s.Key)
+	}
+}
+
+// 撤销注册并立即停止服务（不摘流），可以在测试中调用来模拟实例下线，多次调用没有影响
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		if s.stopReg != nil {
+			s.stopReg()
+			<-s.regDone
+		}
+		s.Stop()
+		<-s.serveDone
+		if s.gwConn != nil {
+			s.gwConn.Close()
+		}
+		closeBuf(s.Addr)
+	})
+}
+
+// 直连这个服务端的target
+func (s *Server) Target() string {
+	return "passthrough:///" + s.Addr
+}
+
+// 访问注册在Registry中的服务的target
+func EtcdTarget(service string) string {
+	return common.MySchemeEtcd + ":///" + service
+}
+
+// HelloService的实现，回复"Hello " + name，和各个例子的服务端一样
+type HelloServer struct {
+	pb.UnimplementedHelloServiceServer
+	Auth *config.AuthConfig // 不为nil时校验token
+
+	calls atomic.Int64
+}
+
+func (h *HelloServer) SayHello(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+	if h.Auth != nil {
+		if err := auth.Check(ctx, *h.Auth); err != nil {
+			return nil, err
+		}
+	}
+	h.calls.Add(1)
+	return &pb.HelloReply{Message: "Hello " + req.Name}, nil
+}
+
+// 成功处理的请求数
+func (h *HelloServer) Calls() int64 {
+	return h.calls.Load()
+}
@@ -0,0 +1,143 @@
+package testutil
+
+import (
+	"context"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-case/config"
+	grpcerrors "grpc-case/errors"
+	"grpc-case/pb"
+	"testing"
+	"time"
+)
+
+var testAuth = config.AuthConfig{AppId: "test", AppKey: "secret"}
+
+// 各个例子的服务端注册到Registry，客户端通过etcd:///解析，请求能发到每个实例上
+func TestHarness(t *testing.T) {
+	cases := []struct {
+		name       string
+		serverOpts []ServerOption
+		clientOpts []ClientOption
+		code       codes.Code // 期望的错误码，OK表示成功
+		reason     string
+	}{
+		{name: "simple"},
+		{name: "tcp", serverOpts: []ServerOption{WithTCP()}},
+		{name: "tls", serverOpts: []ServerOption{WithTLS()}, clientOpts: []ClientOption{WithClientTLS()}},
+		{name: "token", serverOpts: []ServerOption{WithAuth(testAuth)}, clientOpts: []ClientOption{WithToken(testAuth)}},
+		{name: "tls and token", serverOpts: []ServerOption{WithTLS(), WithAuth(testAuth)}, clientOpts: []ClientOption{WithClientTLS(), WithToken(testAuth)}},
+		{name: "missing token", serverOpts: []ServerOption{WithAuth(testAuth)},
+			code: codes.Unauthenticated, reason: grpcerrors.ReasonMissingMetadata},
+		{name: "wrong token", serverOpts: []ServerOption{WithAuth(testAuth)}, clientOpts: []ClientOption{WithToken(config.AuthConfig{AppId: "test", AppKey: "guess"})},
+			code: codes.Unauthenticated, reason: grpcerrors.ReasonInvalidToken},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			const service = "hello_harness"
+			reg := NewRegistry()
+			servers := make([]*Server, 2)
+			for i := range servers {
+				servers[i] = StartServer(t, append(c.serverOpts, WithRegistry(reg, service))...)
+				if got := reg.Keys(servers[i].Key); len(got) != 1 {
+					t.Fatalf("server %d not registered: %v", i, got)
+				}
+			}
+			client := NewHelloClient(t, EtcdTarget(service), append(c.clientOpts, WithResolver(reg))...)
+
+			for i := 0; i < 10; i++ {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				rsp, err := client.SayHello(ctx, &pb.HelloRequest{Name: "harness"})
+				cancel()
+				if c.code != codes.OK {
+					if status.Code(err) != c.code || grpcerrors.Reason(err) != c.reason {
+						t.Fatalf("err = %v, want %v %s", err, c.code, c.reason)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if rsp.Message != "Hello harness" {
+					t.Fatalf("message = %q", rsp.Message)
+				}
+			}
+			// round_robin，两个实例都收到请求；校验失败的请求不计数
+			for i, s := range servers {
+				if got := s.Hello.Calls(); (got > 0) != (c.code == codes.OK) {
+					t.Fatalf("server %d handled %d calls", i, got)
+				}
+			}
+		})
+	}
+}
+
+// 实例下线之后从Registry中删除，请求只发给剩下的实例
+func TestHarnessChurn(t *testing.T) {
+	const service = "hello_churn"
+	reg := NewRegistry()
+	a := StartServer(t, WithRegistry(reg, service))
+	b := StartServer(t, WithRegistry(reg, service))
+	client := NewHelloClient(t, EtcdTarget(service), WithResolver(reg))
+
+	a.Close()
+	if got := reg.Keys(a.Key); len(got) != 0 {
+		t.Fatalf("closed server still registered: %v", got)
+	}
+	before := b.Hello.Calls()
+	for i := 0; i < 10; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := client.SayHello(ctx, &pb.HelloRequest{Name: "churn"})
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := b.Hello.Calls() - before; got != 10 {
+		t.Fatalf("remaining server handled %d of 10 calls", got)
+	}
+}
+
+// Put的租约从请求中取：关联的key在撤销租约时删除，租约不存在时返回ErrLeaseNotFound
+func TestRegistryLease(t *testing.T) {
+	r := NewRegistry()
+	c := r.Client()
+	ctx := context.Background()
+
+	lease, err := c.Grant(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Put(ctx, "/svc/a", "1", clientv3.WithLease(lease.ID)); err != nil {
+		t.Fatal(err)
+	}
+	r.Put("/svc/b", "2")
+	ttl, err := c.TimeToLive(ctx, lease.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ttl.Keys) != 1 || string(ttl.Keys[0]) != "/svc/a" {
+		t.Fatalf("lease keys %q", ttl.Keys)
+	}
+
+	resp, err := c.Put(ctx, "/svc/b", "3", clientv3.WithPrevKV())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.PrevKv == nil || string(resp.PrevKv.Value) != "2" {
+		t.Fatalf("prev kv %v", resp.PrevKv)
+	}
+
+	if _, err := c.Put(ctx, "/svc/c", "4", clientv3.WithLease(lease.ID+100)); err != rpctypes.ErrLeaseNotFound {
+		t.Fatalf("unknown lease: %v", err)
+	}
+
+	if _, err := c.Revoke(ctx, lease.ID); err != nil {
+		t.Fatal(err)
+	}
+	if keys := r.Keys("/svc/"); len(keys) != 1 || keys[0] != "/svc/b" {
+		t.Fatalf("keys after revoke %v", keys)
+	}
+}
@@ -0,0 +1,80 @@
+package testutil
+
+/*
+ * 测试用的TLS证书，进程内生成一次自签名证书，不依赖tls/key下的文件（那里的证书会过期）
+ */
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"google.golang.org/grpc/credentials"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// 证书中的域名，客户端按这个名字校验服务端证书
+const TLSServerName = "grpc-case.test"
+
+var (
+	certOnce sync.Once
+	certPair tls.Certificate
+	certPool *x509.CertPool
+	certErr  error
+)
+
+func loadCert() (tls.Certificate, *x509.CertPool, error) {
+	certOnce.Do(func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			certErr = err
+			return
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: TLSServerName},
+			DNSNames:              []string{TLSServerName},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(24 * time.Hour),
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if err != nil {
+			certErr = err
+			return
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			certErr = err
+			return
+		}
+		certPair = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+		certPool = x509.NewCertPool()
+		certPool.AddCert(cert)
+	})
+	return certPair, certPool, certErr
+}
+
+// 服务端的TLS证书
+func ServerTLS() (credentials.TransportCredentials, error) {
+	cert, _, err := loadCert()
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}}), nil
+}
+
+// 客户端的TLS证书，信任ServerTLS生成的证书
+func ClientTLS() (credentials.TransportCredentials, error) {
+	_, pool, err := loadCert()
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(&tls.Config{RootCAs: pool, ServerName: TLSServerName}), nil
+}
@@ -7,26 +7,56 @@ import (
 	"context"
 	"fmt"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-	"grpc-case/common"
+	"grpc-case/config"
+	"grpc-case/logging"
+	"grpc-case/metrics"
+	"grpc-case/middleware"
 	"grpc-case/pb"
+	"grpc-case/tracing"
 )
 
-const (
-	KeyPath = "/data/share/golang/src/github.com/hq-cml/grpc-case/tls/key/"
-)
-
+// 在仓库根目录下运行 go run ./tls/client
 func main() {
+	// 加载配置，这个例子默认开启TLS
+	cfg := config.MustLoad(
+		config.Alias("target", "client.target"),
+		config.Alias("metrics", "client.metrics_addr"),
+		config.WithDefaults(func(c *config.Config) { c.TLS.Enabled = true }),
+	)
+
+	// 链路追踪，是否导出由环境变量OTEL_TRACES_EXPORTER决定
+	shutdown, err := tracing.Init(context.Background(), "grpc-case-client")
+	if err != nil {
+		panic(err)
+	}
+	defer shutdown(context.Background())
+
+	// 暴露监控指标
+	if cfg.Client.MetricsAddr != "" {
+		if _, err := metrics.Serve(cfg.Client.MetricsAddr); err != nil {
+			panic(err)
+		}
+	}
+
 	// 载入证书，同时需要指定域名访问，如果域名错误，也会失效
-	// creds, err := credentials.NewClientTLSFromFile(KeyPath+"test.pem", "*.baidu.com") // 域名指定不正确，会得到错误
-	creds, err := credentials.NewClientTLSFromFile(KeyPath+"test.pem", "*.hq.com")
+	// go run ./tls/client -tls.server_name '*.baidu.com' // 域名指定不正确，会得到错误
+	creds, err := cfg.TLS.ClientCredentials()
 	if err != nil {
 		panic(err)
 	}
 
 	// 创建链接，此处设置证书
-	//conn, err := grpc.NewClient(common.BackEnd0, grpc.WithTransportCredentials(insecure.NewCredentials())) // 不指定证书，将会得到错误
-	conn, err := grpc.NewClient(common.BackEnd0, grpc.WithTransportCredentials(creds))
+	//conn, err := grpc.NewClient(cfg.Client.Target, grpc.WithTransportCredentials(insecure.NewCredentials())) // 不指定证书，将会得到错误
+	conn, err := grpc.NewClient(cfg.Client.Target,
+		grpc.WithTransportCredentials(creds),
+		tracing.DialOption(), // 链路追踪
+		grpc.WithChainUnaryInterceptor( // 请求ID、日志、监控、超时拦截器
+			middleware.RequestIdUnaryClientInterceptor(),
+			logging.UnaryClientInterceptor(logging.L()),
+			metrics.UnaryClientInterceptor(),
+			middleware.DeadlineUnaryClientInterceptor(cfg.Client.DeadlineMargin, cfg.Client.Timeout),
+		),
+	)
 	if err != nil {
 		panic(err)
 	}
@@ -36,7 +66,9 @@ func main() {
 	client := pb.NewHelloServiceClient(conn)
 
 	// 执行RPC调用
-	rsp, err := client.SayHello(context.Background(), &pb.HelloRequest{
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Client.Timeout)
+	defer cancel()
+	rsp, err := client.SayHello(ctx, &pb.HelloRequest{
 		Name: "foo",
 	})
 	if err != nil {
@@ -5,19 +5,16 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-	"grpc-case/common"
+	"go.uber.org/zap"
+	"grpc-case/bootstrap"
+	"grpc-case/config"
+	"grpc-case/logging"
 	"grpc-case/pb"
+	"grpc-case/tracing"
 	"net"
 	"sync"
 )
 
-const (
-	KeyPath = "/data/share/golang/src/github.com/hq-cml/grpc-case/tls/key/"
-)
-
 // 业务自己的Server，实现各个服务端方法
 type MyServer struct {
 	pb.UnimplementedHelloServiceServer //首先包装一个UnimplementedServer，使自身成为HelloServiceServer接口的实现
@@ -25,28 +22,39 @@ type MyServer struct {
 
 // 实现业务代码
 func (m *MyServer) SayHello(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
-	fmt.Println("Recv Request:", req.Name)
+	logging.WithContext(ctx).Info("recv request", zap.String("name", req.Name))
 	return &pb.HelloReply{
 		Message: "Hello bar",
 	}, nil
 }
 
 // 服务启动起来
+// 在仓库根目录下运行 go run ./tls/server，证书路径默认是相对路径 tls/key/，可以用 -tls.cert_file 等参数修改
 func main() {
-	// 载入证书：两个参数分别是自签证书 & 私钥
-	creds, err := credentials.NewServerTLSFromFile(KeyPath+"test.pem", KeyPath+"test.key")
+	// 加载配置，这个例子默认开启TLS
+	cfg := config.MustLoad(
+		config.Alias("p", "server.port"),
+		config.WithDefaults(func(c *config.Config) { c.TLS.Enabled = true }),
+	)
+
+	// 链路追踪，是否导出由环境变量OTEL_TRACES_EXPORTER决定，每个进程初始化一次
+	shutdownTracing, err := tracing.Init(context.Background(), "grpc-case")
 	if err != nil {
 		panic(err)
 	}
+	defer shutdownTracing(context.Background())
 
 	// 创建监听端口
-	listener, err := net.Listen("tcp", ":"+common.BackEndPort0)
+	listener, err := net.Listen("tcp", cfg.Server.ListenAddr())
 	if err != nil {
 		panic(err)
 	}
 
-	// 创建grpc服务，带上证书！！！
-	grpcServer := grpc.NewServer(grpc.Creds(creds))
+	// 创建grpc服务，带上证书！！！（自签证书 & 私钥，见cfg.TLS）
+	grpcServer, err := bootstrap.NewServerFromConfig(cfg)
+	if err != nil {
+		panic(err)
+	}
 
 	// 在grpc服务中，注册业务自己的服务（也就是将自己的Server对象与grpc服务绑定）
 	pb.RegisterHelloServiceServer(grpcServer, &MyServer{})
@@ -55,7 +63,7 @@ func main() {
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
-		fmt.Println("TLS Server start! Port:" + common.BackEndPort0)
+		logging.L().Info("tls server start", zap.String("addr", listener.Addr().String()))
 		defer wg.Done()
 		err = grpcServer.Serve(listener)
 		if err != nil {
@@ -0,0 +1,63 @@
+/**
+ * Token认证（appId/appKey），服务端的校验和客户端的凭证
+ * 从token的例子中抽出来，方便其他服务端和测试（见testutil）复用
+ */
+package auth
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"grpc-case/common"
+	"grpc-case/config"
+	grpcerrors "grpc-case/errors"
+)
+
+// 模拟Token校验（这个在实际工程上放在拦截器里更合适）
+// 校验失败返回codes.Unauthenticated，并在ErrorInfo中带上具体原因，客户端可以用grpcerrors.Reason判断
+func Check(ctx context.Context, want config.AuthConfig) error {
+	// 从metadata中取出appId和appKey
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return grpcerrors.New(codes.Unauthenticated, grpcerrors.ReasonMissingMetadata, "metadata not found").Err()
+	}
+
+	// 从上下文中取出client带过来的appId和appKey
+	// 注意这里有个坑，必须全小写！metadata.FromIncomingContext有注释解释
+	var appId, appKey string
+	if v, ok := md[common.MetaAppId]; ok {
+		appId = v[0]
+	}
+	if v, ok := md[common.MetaAppKey]; ok {
+		appKey = v[0]
+	}
+	if appId == "" || appKey == "" {
+		return grpcerrors.New(codes.Unauthenticated, grpcerrors.ReasonMissingMetadata, "appId and appKey are required").Err()
+	}
+
+	// 这里模拟从某个存储上（这里是配置），取出服务端维护的appId和appKey
+	if appId != want.AppId || appKey != want.AppKey {
+		return grpcerrors.New(codes.Unauthenticated, grpcerrors.ReasonInvalidToken, "invalid appId or appKey").
+			Meta(common.MetaAppId, appId).Err()
+	}
+	return nil
+}
+
+// 自实现Token认证，实现credentials.PerRPCCredentials接口
+type Credentials struct {
+	Auth config.AuthConfig
+}
+
+// 从配置中取出appId和appKey
+// 这东西要带给服务端，去做多租校验
+func (c *Credentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{
+		"appId":  c.Auth.AppId,
+		"appKey": c.Auth.AppKey,
+	}, nil
+}
+
+// 如果返回true，则可以实现Token认证和TLS认证的叠加
+func (c *Credentials) RequireTransportSecurity() bool {
+	return false
+}
@@ -7,42 +7,53 @@ import (
 	"context"
 	"fmt"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-	"grpc-case/common"
+	"grpc-case/config"
+	grpcerrors "grpc-case/errors"
+	"grpc-case/logging"
+	"grpc-case/metrics"
+	"grpc-case/middleware"
 	"grpc-case/pb"
+	"grpc-case/token/auth"
+	"grpc-case/tracing"
 )
 
-// 自实现Token认证，实现credentials.PerRPCCredentials接口
-type MyClientTokenAuth struct {
-}
+// go run client.go -auth.app_key xyz // appKey不对，会得到INVALID_TOKEN
+func main() {
+	// 加载配置
+	cfg := config.MustLoad(config.Alias("target", "client.target"), config.Alias("metrics", "client.metrics_addr"))
 
-// 模拟从url中、或者其他什么东西中、例如配置之类的，取出appId和appKey
-// 这东西要带给服务端，去做多租校验
-func (m *MyClientTokenAuth) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
-	return map[string]string{
-		"appId":  common.AppId,
-		"appKey": common.AppKey,
-	}, nil
-}
+	// 链路追踪，是否导出由环境变量OTEL_TRACES_EXPORTER决定
+	shutdown, err := tracing.Init(context.Background(), "grpc-case-client")
+	if err != nil {
+		panic(err)
+	}
+	defer shutdown(context.Background())
 
-// 如果返回true，则可以实现Token认证和TLS认证的叠加
-func (m *MyClientTokenAuth) RequireTransportSecurity() bool {
-	return false
-}
+	// 暴露监控指标
+	if cfg.Client.MetricsAddr != "" {
+		if _, err := metrics.Serve(cfg.Client.MetricsAddr); err != nil {
+			panic(err)
+		}
+	}
 
-func main() {
-	// 载入证书，同时需要指定域名访问，如果域名错误，也会失效
-	// creds, err := credentials.NewClientTLSFromFile(KeyPath+"test.pem", "*.baidu.com") // 域名指定不正确，会得到错误
-	//creds, err := credentials.NewClientTLSFromFile(KeyPath+"test.pem", "*.hq.com")
-	//if err != nil {
-	//	panic(err)
-	//}
+	// 载入证书（默认不使用tls，-tls.enabled开启）
+	creds, err := cfg.TLS.ClientCredentials()
+	if err != nil {
+		panic(err)
+	}
 
 	// 创建连接
-	//conn, err := grpc.NewClient(common.BackEnd0, grpc.WithTransportCredentials(creds))
-	conn, err := grpc.NewClient(common.BackEnd0,
-		grpc.WithTransportCredentials(insecure.NewCredentials()), // 不使用tls
-		grpc.WithPerRPCCredentials(new(MyClientTokenAuth)))       // 使用自实现的Token
+	conn, err := grpc.NewClient(cfg.Client.Target,
+		grpc.WithTransportCredentials(creds),
+		tracing.DialOption(),                                          // 链路追踪
+		grpc.WithPerRPCCredentials(&auth.Credentials{Auth: cfg.Auth}), // 使用自实现的Token（见token/auth）
+		grpc.WithChainUnaryInterceptor( // 请求ID、日志、监控、超时拦截器
+			middleware.RequestIdUnaryClientInterceptor(),
+			logging.UnaryClientInterceptor(logging.L()),
+			metrics.UnaryClientInterceptor(),
+			middleware.DeadlineUnaryClientInterceptor(cfg.Client.DeadlineMargin, cfg.Client.Timeout),
+		),
+	)
 	if err != nil {
 		panic(err)
 	}
@@ -52,10 +63,14 @@ func main() {
 	client := pb.NewHelloServiceClient(conn)
 
 	// 执行RPC调用
-	rsp, err := client.SayHello(context.Background(), &pb.HelloRequest{
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Client.Timeout)
+	defer cancel()
+	rsp, err := client.SayHello(ctx, &pb.HelloRequest{
 		Name: "haha",
 	})
 	if err != nil {
+		// 服务端返回的是带ErrorInfo的错误，可以根据Reason判断具体原因（比如INVALID_TOKEN）
+		fmt.Println("Reason: ", grpcerrors.Reason(err))
 		panic(err)
 	}
 
@@ -5,14 +5,17 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/metadata"
-	"grpc-case/common"
+	"grpc-case/bootstrap"
+	"grpc-case/config"
+	"grpc-case/gateway"
+	"grpc-case/logging"
+	"grpc-case/middleware"
 	"grpc-case/pb"
+	"grpc-case/token/auth"
+	"grpc-case/tracing"
 	"net"
 	"sync"
 )
@@ -20,80 +23,101 @@ import (
 // 业务自己的Server，实现各个服务端方法
 type MyServer struct {
 	pb.UnimplementedHelloServiceServer //首先包装一个UnimplementedServer，使自身成为HelloServiceServer接口的实现
-}
-
-// 模拟Token校验（这个在实际工程上放在拦截器里更合适）
-func (m *MyServer) check(ctx context.Context) (bool, string) {
-	// 从metadata中取出appId和appKey
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return false, "获取失败"
-	}
-	s, _ := json.Marshal(md)
-	fmt.Println("X---------", string(s))
-
-	// 从上下文中取出client带过来的appId和appKey
-	// 注意这里有个坑，必须全小写！metadata.FromIncomingContext有注释解释
-	var appId, appKey string
-	if v, ok := md["appid"]; ok {
-		appId = v[0]
-	}
-	if v, ok := md["appkey"]; ok {
-		appKey = v[0]
-	}
-
-	// 这里模拟从某个存储上，取出服务端维护的appId和appKey
-	if appId != common.AppId || appKey != common.AppKey {
-		return false, "校验失败"
-	}
-	return true, ""
+	auth                               config.AuthConfig
 }
 
 // 实现业务代码
 func (m *MyServer) SayHello(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
 	// token校验
-	ok, msg := m.check(ctx)
-	if !ok {
-		return nil, errors.New(msg)
+	if err := auth.Check(ctx, m.auth); err != nil {
+		return nil, err
 	}
 
-	fmt.Println("Recv Request:", req.Name)
+	logging.WithContext(ctx).Info("recv request", zap.String("name", req.Name))
 	return &pb.HelloReply{
 		Message: "Hello " + req.Name,
 	}, nil
 }
 
 // 服务启动起来
+// 加上 -gw 在同一个端口上同时提供HTTP/JSON网关
+// curl -H 'appid: 123' -H 'appkey: abc' -d '{"name":"foo"}' http://127.0.0.1:9090/v1/hello
+// 同时开启TLS时，网关单独监听 -server.gateway_addr（比如:8443）
 func main() {
-	// 载入证书：两个参数分别是自签证书 & 私钥
-	//creds, err := credentials.NewServerTLSFromFile(KeyPath+"test.pem", KeyPath+"test.key")
-	//if err != nil {
-	//	panic(err)
-	//}
+	// 加载配置
+	cfg := config.MustLoad(config.Alias("p", "server.port"), config.Alias("gw", "server.gateway"))
+
+	// 链路追踪，是否导出由环境变量OTEL_TRACES_EXPORTER决定，每个进程初始化一次
+	shutdownTracing, err := tracing.Init(context.Background(), "grpc-case")
+	if err != nil {
+		panic(err)
+	}
+	defer shutdownTracing(context.Background())
 
 	// 创建监听端口
-	listener, err := net.Listen("tcp", ":"+common.BackEndPort0)
+	listener, err := net.Listen("tcp", cfg.Server.ListenAddr())
 	if err != nil {
 		panic(err)
 	}
 
-	// 创建grpc服务
-	//grpcServer := grpc.NewServer(grpc.Creds(creds))
-	grpcServer := grpc.NewServer(grpc.Creds(insecure.NewCredentials())) // 不使用tls
+	// 创建grpc服务（默认不使用tls，-tls.enabled开启）
+	grpcServer, err := bootstrap.NewServerFromConfig(cfg)
+	if err != nil {
+		panic(err)
+	}
 
 	// 在grpc服务中，注册业务自己的服务（也就是将自己的Server对象与grpc服务绑定）
-	pb.RegisterHelloServiceServer(grpcServer, &MyServer{})
+	pb.RegisterHelloServiceServer(grpcServer, &MyServer{auth: cfg.Auth})
 
 	// 启动grpc服务
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
-		fmt.Println("Token Server start! Port:" + common.BackEndPort0)
+		logging.L().Info("token server start", zap.String("addr", listener.Addr().String()), zap.Bool("gateway", cfg.Server.Gateway))
 		defer wg.Done()
-		err = grpcServer.Serve(listener)
+		if cfg.Server.Gateway {
+			err = serveWithGateway(cfg, listener, grpcServer)
+		} else {
+			err = grpcServer.Serve(listener)
+		}
 		if err != nil {
 			panic(err)
 		}
 	}()
 	wg.Wait()
 }
+
+// 同端口提供grpc和HTTP服务，HTTP请求经过网关转成grpc请求再发给自己
+// 请求头里的appid和appkey会被透传，所以HTTP请求同样需要通过token校验
+// 开启TLS时，网关连自己同样要用TLS，HTTP单独监听cfg.Server.GatewayAddr
+func serveWithGateway(cfg *config.Config, listener net.Listener, grpcServer *bootstrap.Server) error {
+	creds, err := cfg.TLS.ClientCredentials()
+	if err != nil {
+		return err
+	}
+	conn, err := grpc.NewClient(selfTarget(listener.Addr()),
+		grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(middleware.DeadlineUnaryClientInterceptor(cfg.Client.DeadlineMargin, cfg.Client.Timeout)),
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	h, err := gateway.NewHandler(context.Background(), conn)
+	if err != nil {
+		return err
+	}
+	if cfg.TLS.Enabled {
+		return gateway.ServeTLS(listener, grpcServer, cfg.Server.GatewayAddr, &cfg.TLS, h)
+	}
+	return gateway.ServeMux(listener, grpcServer, h)
+}
+
+// 网关连自己用的地址，监听的是所有网卡时用127.0.0.1
+func selfTarget(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok && tcpAddr.IP.IsUnspecified() {
+		return fmt.Sprintf("127.0.0.1:%d", tcpAddr.Port)
+	}
+	return addr.String()
+}
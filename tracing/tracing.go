@@ -0,0 +1,108 @@
+/**
+ * 分布式链路追踪
+ * 基于OpenTelemetry：
+ *  1. 客户端/服务端使用otelgrpc的StatsHandler，自动生成span，并通过grpc的metadata传递W3C trace context（traceparent）
+ *  2. resolver的地址更新、picker的选择结果，作为span event记录下来
+ *  3. 导出方式遵循OTel的标准环境变量 OTEL_TRACES_EXPORTER：
+ *     console/stdout：打印到标准输出
+ *     otlp：通过OTLP/gRPC导出，地址由 OTEL_EXPORTER_OTLP_ENDPOINT 指定（默认localhost:4317）
+ *     none或者不设置：不导出，所有span都是noop，几乎没有开销
+ *  4. 测试时可以用InitInMemory，把span收集到内存里做断言
+ */
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"os"
+)
+
+const (
+	ExporterEnv    = "OTEL_TRACES_EXPORTER"
+	ServiceNameEnv = "OTEL_SERVICE_NAME"
+
+	// 本项目自己的span（resolver、picker）使用的tracer名字
+	TracerName = "grpc-case"
+)
+
+func init() {
+	// W3C trace context + baggage，不管有没有开启导出，都先设置好传播方式
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// 根据环境变量初始化全局的TracerProvider，返回的函数用于退出前把剩余的span刷出去
+// serviceName在环境变量OTEL_SERVICE_NAME没有设置时使用
+func Init(ctx context.Context, serviceName string) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch os.Getenv(ExporterEnv) {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "console", "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "otlp":
+		exporter, err = otlptracegrpc.New(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported %v: %v", ExporterEnv, os.Getenv(ExporterEnv))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if name := os.Getenv(ServiceNameEnv); name != "" {
+		serviceName = name
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// 测试用：span同步写入内存，可以通过exporter.GetSpans()取出来断言
+func InitInMemory() (*tracetest.InMemoryExporter, *sdktrace.TracerProvider) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(tp)
+	return exporter, tp
+}
+
+// 服务端的StatsHandler
+func ServerOption() grpc.ServerOption {
+	return grpc.StatsHandler(otelgrpc.NewServerHandler())
+}
+
+// 客户端的StatsHandler
+func DialOption() grpc.DialOption {
+	return grpc.WithStatsHandler(otelgrpc.NewClientHandler())
+}
+
+// 在ctx当前的span上记录一个event，没有span时什么都不做
+func AddEvent(ctx context.Context, name string, attrs ...attribute.KeyValue) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	span.AddEvent(name, trace.WithAttributes(attrs...))
+}
+
+// 没有请求上下文的地方（比如resolver收到etcd的变更），单独生成一个很短的span来记录event
+func RecordEvent(spanName, name string, attrs ...attribute.KeyValue) {
+	_, span := otel.Tracer(TracerName).Start(context.Background(), spanName)
+	defer span.End()
+	span.AddEvent(name, trace.WithAttributes(attrs...))
+}
@@ -0,0 +1,97 @@
+package tracing_test
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"grpc-case/pb"
+	"grpc-case/testutil"
+	"grpc-case/tracing"
+	"testing"
+	"time"
+)
+
+func findSpan(spans tracetest.SpanStubs, name string, kind trace.SpanKind) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name && spans[i].SpanKind == kind {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+func hasEvent(s *tracetest.SpanStub, name string, attr attribute.KeyValue) bool {
+	for _, ev := range s.Events {
+		if ev.Name != name {
+			continue
+		}
+		for _, a := range ev.Attributes {
+			if a == attr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 经过etcd resolver和my_balancer的一次调用：服务端的span和客户端的span在同一个trace中（traceparent通过metadata传递），
+// picker的选择记录在客户端的span上，resolver的地址更新单独记录
+func TestPropagation(t *testing.T) {
+	exporter, tp := tracing.InitInMemory()
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	const service = "hello_tracing"
+	reg := testutil.NewRegistry()
+	s := testutil.StartServer(t, testutil.WithRegistry(reg, service))
+	client := testutil.NewHelloClient(t, testutil.EtcdTarget(service), testutil.WithResolver(reg),
+		testutil.WithBalancer("my_balancer"), testutil.WithDialOptions(tracing.DialOption()))
+
+	// 调用方自己的span，作为整个trace的根
+	ctx, root := tp.Tracer("test").Start(context.Background(), "root")
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	_, err := client.SayHello(ctx, &pb.HelloRequest{Name: "tracing"})
+	cancel()
+	root.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	method := pb.HelloService_SayHello_FullMethodName[1:]
+	var clientSpan, serverSpan *tracetest.SpanStub
+	// 服务端的span在handler返回之后才结束，可能比客户端收到回复稍晚
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		spans := exporter.GetSpans()
+		clientSpan, serverSpan = findSpan(spans, method, trace.SpanKindClient), findSpan(spans, method, trace.SpanKindServer)
+		if clientSpan != nil && serverSpan != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("client span %v, server span %v", clientSpan != nil, serverSpan != nil)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	traceId := root.SpanContext().TraceID()
+	if clientSpan.SpanContext.TraceID() != traceId || clientSpan.Parent.SpanID() != root.SpanContext().SpanID() {
+		t.Fatalf("client span not under root: %v", clientSpan.Parent)
+	}
+	if serverSpan.SpanContext.TraceID() != traceId {
+		t.Fatalf("server trace %v, want %v", serverSpan.SpanContext.TraceID(), traceId)
+	}
+	if !serverSpan.Parent.IsRemote() || serverSpan.Parent.SpanID() != clientSpan.SpanContext.SpanID() {
+		t.Fatalf("server span parent %v, want remote client span %v", serverSpan.Parent, clientSpan.SpanContext.SpanID())
+	}
+	if !hasEvent(clientSpan, "pick", attribute.String("addr", s.Addr)) {
+		t.Fatalf("pick event not recorded on client span: %v", clientSpan.Events)
+	}
+
+	var resolved bool
+	for _, span := range exporter.GetSpans() {
+		resolved = resolved || span.Name == "resolver.UpdateState" && hasEvent(&span, "addresses updated", attribute.StringSlice("addrs", []string{s.Addr}))
+	}
+	if !resolved {
+		t.Fatal("resolver update not recorded")
+	}
+}
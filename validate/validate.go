@@ -0,0 +1,167 @@
+/**
+ * 请求参数校验
+ * 约束写在proto文件里（字段option：(grpccase.validate.rules)，定义见pb/validate.proto），这里根据约束对消息做校验
+ *  1. 服务端拦截器：校验失败返回codes.InvalidArgument，错误详情里带上BadRequest（每个字段的违规原因）
+ *  2. 客户端拦截器（可选）：发送之前先校验，不合法的请求不用再走一遍网络
+ */
+package validate
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	grpcerrors "grpc-case/errors"
+	"grpc-case/pb"
+	"regexp"
+	"sync"
+	"unicode/utf8"
+)
+
+// 编译过的正则缓存起来，pattern => *regexp.Regexp
+var patterns sync.Map
+
+// 校验消息，返回所有不合法的字段，没有问题返回nil
+func Check(msg proto.Message) []*errdetails.BadRequest_FieldViolation {
+	if msg == nil {
+		return nil
+	}
+	return checkMessage(msg.ProtoReflect(), "")
+}
+
+// 校验消息，不合法时返回带BadRequest详情的InvalidArgument错误
+func Validate(msg proto.Message) error {
+	violations := Check(msg)
+	if len(violations) == 0 {
+		return nil
+	}
+	return grpcerrors.New(codes.InvalidArgument, grpcerrors.ReasonInvalidArgument,
+		"invalid %v: %v", msg.ProtoReflect().Descriptor().Name(), violations[0].Description).
+		FieldViolations(violations...).Err()
+}
+
+// 服务端一元拦截器
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if msg, ok := req.(proto.Message); ok {
+			if err := Validate(msg); err != nil {
+				return nil, err
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// 服务端流式拦截器，校验每一个收到的消息
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &validatingStream{ServerStream: ss})
+	}
+}
+
+// 客户端一元拦截器，发送之前先校验
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if msg, ok := req.(proto.Message); ok {
+			if err := Validate(msg); err != nil {
+				return err
+			}
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+type validatingStream struct {
+	grpc.ServerStream
+}
+
+func (s *validatingStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if msg, ok := m.(proto.Message); ok {
+		return Validate(msg)
+	}
+	return nil
+}
+
+func checkMessage(m protoreflect.Message, prefix string) []*errdetails.BadRequest_FieldViolation {
+	var violations []*errdetails.BadRequest_FieldViolation
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		path := prefix + string(fd.Name())
+
+		if rules := fieldRules(fd); rules != nil {
+			violations = append(violations, checkField(m, fd, rules, path)...)
+		}
+
+		// 嵌套的消息，递归校验
+		if fd.Kind() == protoreflect.MessageKind && !fd.IsMap() && m.Has(fd) {
+			if fd.IsList() {
+				list := m.Get(fd).List()
+				for j := 0; j < list.Len(); j++ {
+					violations = append(violations, checkMessage(list.Get(j).Message(), fmt.Sprintf("%v[%d].", path, j))...)
+				}
+			} else {
+				violations = append(violations, checkMessage(m.Get(fd).Message(), path+".")...)
+			}
+		}
+	}
+	return violations
+}
+
+func fieldRules(fd protoreflect.FieldDescriptor) *pb.FieldRules {
+	opts := fd.Options()
+	if opts == nil || !proto.HasExtension(opts, pb.E_Rules) {
+		return nil
+	}
+	return proto.GetExtension(opts, pb.E_Rules).(*pb.FieldRules)
+}
+
+func checkField(m protoreflect.Message, fd protoreflect.FieldDescriptor, rules *pb.FieldRules, path string) []*errdetails.BadRequest_FieldViolation {
+	if rules.GetRequired() && !m.Has(fd) {
+		return []*errdetails.BadRequest_FieldViolation{violation(path, "is required")}
+	}
+	if fd.Kind() != protoreflect.StringKind || fd.IsList() || fd.IsMap() || !m.Has(fd) {
+		return nil
+	}
+
+	var violations []*errdetails.BadRequest_FieldViolation
+	s := m.Get(fd).String()
+	n := uint32(utf8.RuneCountInString(s))
+	if rules.GetMinLen() > 0 && n < rules.GetMinLen() {
+		violations = append(violations, violation(path, fmt.Sprintf("length must be at least %d", rules.GetMinLen())))
+	}
+	if rules.GetMaxLen() > 0 && n > rules.GetMaxLen() {
+		violations = append(violations, violation(path, fmt.Sprintf("length must be at most %d", rules.GetMaxLen())))
+	}
+	if p := rules.GetPattern(); p != "" {
+		re, err := compile(p)
+		if err != nil {
+			violations = append(violations, violation(path, fmt.Sprintf("invalid pattern %q in rules", p)))
+		} else if !re.MatchString(s) {
+			violations = append(violations, violation(path, fmt.Sprintf("must match pattern %q", p)))
+		}
+	}
+	return violations
+}
+
+func compile(p string) (*regexp.Regexp, error) {
+	if v, ok := patterns.Load(p); ok {
+		return v.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(p, re)
+	return re, nil
+}
+
+func violation(field, desc string) *errdetails.BadRequest_FieldViolation {
+	return &errdetails.BadRequest_FieldViolation{Field: field, Description: field + " " + desc}
+}
@@ -0,0 +1,111 @@
+package validate
+
+import (
+	"context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-case/pb"
+	"strings"
+	"testing"
+)
+
+// hello.proto中name的约束：required、max_len: 64、pattern: "^[A-Za-z0-9_]+$"
+var cases = []struct {
+	name string
+	req  *pb.HelloRequest
+	want string // 违规描述中包含的内容，空表示合法
+}{
+	{"valid", &pb.HelloRequest{Name: "grpc_case"}, ""},
+	{"64 runes", &pb.HelloRequest{Name: strings.Repeat("a", 64)}, ""},
+	{"empty", &pb.HelloRequest{}, "is required"},
+	{"too long", &pb.HelloRequest{Name: strings.Repeat("a", 65)}, "at most 64"},
+	{"pattern", &pb.HelloRequest{Name: "a-b"}, "must match pattern"},
+}
+
+func TestCheck(t *testing.T) {
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			violations := Check(c.req)
+			if c.want == "" {
+				if len(violations) != 0 {
+					t.Fatalf("unexpected violations %v", violations)
+				}
+				return
+			}
+			if len(violations) != 1 || violations[0].Field != "name" || !strings.Contains(violations[0].Description, c.want) {
+				t.Fatalf("violations = %v, want name %q", violations, c.want)
+			}
+		})
+	}
+}
+
+// 取出错误中的BadRequest
+func badRequest(t *testing.T, err error) *errdetails.BadRequest {
+	t.Helper()
+	s, ok := status.FromError(err)
+	if !ok || s.Code() != codes.InvalidArgument {
+		t.Fatalf("err = %v, want InvalidArgument", err)
+	}
+	for _, d := range s.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			return br
+		}
+	}
+	t.Fatalf("no BadRequest in %v", s.Details())
+	return nil
+}
+
+// 服务端拦截器：不合法的请求不会到handler，违规的字段通过BadRequest返回
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var called bool
+			_, err := interceptor(context.Background(), c.req, &grpc.UnaryServerInfo{FullMethod: pb.HelloService_SayHello_FullMethodName},
+				func(ctx context.Context, req any) (any, error) {
+					called = true
+					return &pb.HelloReply{}, nil
+				})
+			if c.want == "" {
+				if err != nil || !called {
+					t.Fatalf("valid request: err = %v, called = %v", err, called)
+				}
+				return
+			}
+			if called {
+				t.Fatal("handler called for invalid request")
+			}
+			br := badRequest(t, err)
+			if len(br.FieldViolations) != 1 || br.FieldViolations[0].Field != "name" || !strings.Contains(br.FieldViolations[0].Description, c.want) {
+				t.Fatalf("field violations = %v, want name %q", br.FieldViolations, c.want)
+			}
+		})
+	}
+}
+
+// 客户端拦截器：不合法的请求在发送之前就被拒绝
+func TestUnaryClientInterceptor(t *testing.T) {
+	interceptor := UnaryClientInterceptor()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var sent bool
+			err := interceptor(context.Background(), pb.HelloService_SayHello_FullMethodName, c.req, &pb.HelloReply{}, nil,
+				func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+					sent = true
+					return nil
+				})
+			if c.want == "" {
+				if err != nil || !sent {
+					t.Fatalf("valid request: err = %v, sent = %v", err, sent)
+				}
+				return
+			}
+			if sent {
+				t.Fatal("invalid request sent")
+			}
+			badRequest(t, err)
+		})
+	}
+}